@@ -4,11 +4,14 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"os"
 	"runtime"
 	"strings"
 
 	"github.com/composer22/coreos-artifactory-monitor/logger"
 	"github.com/composer22/coreos-artifactory-monitor/server"
+	"github.com/composer22/coreos-artifactory-monitor/validate"
 )
 
 var (
@@ -37,19 +40,59 @@ func main() {
 	flag.StringVar(&opts.DeployURL, "deploy_url", "", "URL of the coreos-deploy service.")
 	flag.StringVar(&opts.DeployToken, "k", "", "Token to access the coreos-deploy service.")
 	flag.StringVar(&opts.DeployToken, "deploy_token", "", "Token to access the coreos-deploy service.")
+	flag.Var((*server.StringMapValue)(&opts.DeployClusters), "deploy_clusters",
+		"URLs of the coreos-deploy service by cluster (cluster=url,...).")
 
 	flag.StringVar(&opts.ArtAPIEndpoint, "a", "", "Artifactory API Endpoint.")
 	flag.StringVar(&opts.ArtAPIEndpoint, "art_endpoint", "", "Artifactory API Endpoint.")
+	flag.StringVar(&opts.ArtAuthMode, "art_auth", server.DefaultArtAuthMode, "Artifactory authentication mode.")
 	flag.StringVar(&opts.ArtUserID, "u", "", "Artifactory User ID.")
 	flag.StringVar(&opts.ArtUserID, "art_userid", "", "Artifactory User ID.")
 	flag.StringVar(&opts.ArtPassword, "w", "", "Artifactory Password.")
 	flag.StringVar(&opts.ArtPassword, "art_password", "", "Artifactory Password.")
+	flag.StringVar(&opts.ArtAPIKey, "art_apikey", "", "Artifactory API key.")
+	flag.StringVar(&opts.ArtAccessToken, "art_token", "", "Artifactory access token.")
+	flag.StringVar(&opts.ArtRefreshToken, "art_refresh_token", "", "Artifactory refresh token.")
 	flag.IntVar(&opts.ArtPollingInterval, "g", server.DefaultPollingInterval, "Artifactory polling time in seconds.")
 	flag.IntVar(&opts.ArtPollingInterval, "art_polling", server.DefaultPollingInterval, "Artifactory polling time in seconds.")
 	flag.StringVar(&opts.ArtDeployRepo, "t", "", "Name of the repo for deploy requests.")
 	flag.StringVar(&opts.ArtDeployRepo, "art_deploy_repo", "", "Name of the repo for deploy requests.")
 	flag.StringVar(&opts.ArtPayloadRepo, "y", "", "Name of the repo for payloads.")
 	flag.StringVar(&opts.ArtPayloadRepo, "art_payload_repo", "", "Name of the repo for payloads.")
+	flag.IntVar(&opts.MaxDeploys, "max_deploys", server.DefaultMaxDeploys, "Maximum deploy jobs running at once.")
+	flag.IntVar(&opts.HTTPTimeout, "http_timeout", server.DefaultHTTPTimeout, "Timeout in seconds of API requests.")
+	flag.IntVar(&opts.DownloadTimeout, "download_timeout", server.DefaultDownloadTimeout,
+		"Timeout in seconds of payload downloads.")
+	flag.IntVar(&opts.MaxPayloadSize, "max_payload_size", server.DefaultMaxPayloadSize,
+		"Maximum size in MB of payload downloads.")
+	flag.StringVar(&opts.PayloadCacheDir, "payload_cache_dir", server.DefaultPayloadCacheDir,
+		"Directory of the verified payloads cached.")
+	flag.IntVar(&opts.PayloadCacheSize, "payload_cache_size", server.DefaultPayloadCacheSize,
+		"Maximum size in MB of the payload cache.")
+	flag.IntVar(&opts.HTTPRetries, "http_retries", server.DefaultHTTPRetries, "Maximum retries of GET requests.")
+	flag.IntVar(&opts.HTTPBackoff, "http_backoff", server.DefaultHTTPBackoff, "Backoff in ms before the first retry.")
+	flag.IntVar(&opts.HTTPBackoffMax, "http_backoff_max", server.DefaultHTTPBackoffMax,
+		"Maximum backoff in ms between retries.")
+	flag.IntVar(&opts.HTTPRetryBudget, "http_retry_budget", server.DefaultHTTPRetryBudget,
+		"Maximum seconds spent retrying a request.")
+	flag.IntVar(&opts.ReadyPollIntervals, "ready_polls", server.DefaultReadyPolls,
+		"Polling intervals allowed since the last successful poll when ready.")
+	flag.StringVar(&opts.TLSCAFile, "tls_ca_file", "", "PEM CA bundle trusted by outbound connections.")
+	flag.StringVar(&opts.TLSCertFile, "tls_cert_file", "", "PEM client certificate of outbound connections.")
+	flag.StringVar(&opts.TLSKeyFile, "tls_key_file", "", "PEM key of the client certificate.")
+	flag.StringVar(&opts.TLSMinVersion, "tls_min_version", "", "Minimum TLS version of outbound connections.")
+	flag.StringVar(&opts.WebhookSecret, "webhook_secret", "", "Shared secret for artifactory webhooks.")
+	flag.StringVar(&opts.ArtDiscovery, "art_discovery", server.DefaultDiscovery, "How deploy requests are discovered.")
+	flag.StringVar(&opts.VersionOrder, "version_order", server.DefaultVersionOrder, "How deploy versions are ordered.")
+	flag.Var((*server.StringMapValue)(&opts.AppVersionOrders), "app_version_order",
+		"Version ordering by application (app=order,...).")
+	flag.StringVar(&opts.ScheduleFile, "schedule_file", "", "JSON file of deploy windows and freezes.")
+	flag.StringVar(&opts.PropertiesFile, "properties_file", "", "JSON file of the payload properties required.")
+	flag.StringVar(&opts.SignaturesFile, "signatures_file", "", "JSON file of the trusted payload signing keys.")
+	flag.Var((*server.StringListValue)(&opts.AppIncludes), "app_include", "Pattern of applications to manage.")
+	flag.Var((*server.StringListValue)(&opts.AppExcludes), "app_exclude", "Pattern of applications not to manage.")
+	flag.StringVar(&opts.FilterFile, "filter_file", "", "JSON file of application patterns.")
+	flag.StringVar(&opts.TargetsFile, "targets_file", "", "JSON file of the domains and environments to manage.")
 
 	flag.IntVar(&opts.Port, "p", server.DefaultPort, "Port to listen on for http requests.")
 	flag.IntVar(&opts.Port, "port", server.DefaultPort, "Port to listen on for http requests.")
@@ -71,6 +114,11 @@ func main() {
 		server.PrintVersionAndExit()
 	}
 
+	// Payload validation request?
+	if args := flag.Args(); len(args) > 0 && args[0] == "validate-payload" {
+		os.Exit(validatePayloads(args[1:]))
+	}
+
 	// Check additional params beyond the flags.
 	for _, arg := range flag.Args() {
 		switch strings.ToLower(arg) {
@@ -99,3 +147,34 @@ func main() {
 		log.Errorf(err.Error())
 	}
 }
+
+// validatePayloads checks payload files for CI, printing the problems of each, and returns the exit code.
+func validatePayloads(files []string) int {
+	if len(files) == 0 {
+		fmt.Println("Usage: coreos-artifactory-monitor validate-payload FILE.tar.gz...")
+		return 2
+	}
+	code := 0
+	for _, file := range files {
+		p, err := validate.File(file)
+		if err == nil {
+			fmt.Printf("%s: OK\n", file)
+			for _, u := range p.Units {
+				fmt.Printf("    %s %s, %d instances, %s\n", u.Meta.Name, u.Meta.Version, u.Meta.NumInstances,
+					u.ServiceFile)
+			}
+			continue
+		}
+		code = 1
+		verr, ok := err.(*validate.Error)
+		if !ok {
+			fmt.Printf("%s: %s\n", file, err.Error())
+			continue
+		}
+		fmt.Printf("%s: INVALID\n", file)
+		for _, problem := range verr.Problems {
+			fmt.Printf("    %s\n", problem)
+		}
+	}
+	return code
+}
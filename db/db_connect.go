@@ -13,14 +13,31 @@ const (
 	Failed
 )
 
+// Deploy event types.
+const (
+	EventDeploy   = "deploy"   // A newer version was requested.
+	EventRollback = "rollback" // The deployed version was withdrawn and an older version redeployed.
+)
+
+const (
+	maxMessageLength  = 1024 // The size of the message column.
+	MaxManifestLength = 2048 // The size of the manifest column.
+	MaxUnitsLength    = 2048 // The size of the units column.
+)
+
 type DBConnect struct {
 	db *sql.DB
 }
 
 // NewDBConnect is a factory method that returns a new db connection
 func NewDBConnect(dsn string) (*DBConnect, error) {
+	return NewDBConnectDriver("mysql", dsn)
+}
 
-	db, err := sql.Open("mysql", dsn)
+// NewDBConnectDriver is a factory method that returns a new db connection using a registered
+// database/sql driver. See NewDBConnect.
+func NewDBConnectDriver(driver string, dsn string) (*DBConnect, error) {
+	db, err := sql.Open(driver, dsn)
 	if err != nil {
 		return nil, err
 	}
@@ -56,7 +73,8 @@ func (d *DBConnect) StartDeploy(domain string, environment string, name string,
 	result, err := d.db.Exec("INSERT INTO artifactory_deploys (domain, environment, service_name, version, "+
 		"status, updated_at, created_at) "+
 		"VALUES (?, ?, ?, ?, ?,  NOW(), NOW())"+
-		"ON DUPLICATE KEY UPDATE status = ?, version = ?, updated_at = NOW()",
+		"ON DUPLICATE KEY UPDATE status = ?, version = ?, checksum = '', message = '', manifest = '', units = '', "+
+		"updated_at = NOW()",
 		domain, environment, name, version, Started, Started, version)
 	if err != nil {
 		return false
@@ -68,15 +86,94 @@ func (d *DBConnect) StartDeploy(domain string, environment string, name string,
 	return true
 }
 
-// UpdateDeploy updates the deploy row with information from the run.
+// UpdateDeploy updates the deploy row with information from the run. The message records why a deploy failed.
 func (d *DBConnect) UpdateDeployByName(domain string, environment string, name string,
-	deployID string, status int) bool {
+	deployID string, status int, message string) bool {
+	if len(message) > maxMessageLength {
+		message = message[:maxMessageLength]
+	}
 	result, err := d.db.Exec("UPDATE artifactory_deploys "+
 		"SET deploy_id = ?, "+
 		"status = ?, "+
+		"message = ?, "+
+		"updated_at = NOW() "+
+		"WHERE domain = ? AND environment = ? AND service_name = ?",
+		deployID, status, message, domain, environment, name)
+	if err != nil {
+		return false
+	}
+	rows, err := result.RowsAffected()
+	if err != nil || rows != 1 {
+		return false
+	}
+	return true
+}
+
+// UpdateDeployChecksum records the verified checksum of the payload being deployed.
+func (d *DBConnect) UpdateDeployChecksum(domain string, environment string, name string, checksum string) bool {
+	result, err := d.db.Exec("UPDATE artifactory_deploys "+
+		"SET checksum = ?, "+
 		"updated_at = NOW() "+
 		"WHERE domain = ? AND environment = ? AND service_name = ?",
-		deployID, status, domain, environment, name)
+		checksum, domain, environment, name)
+	if err != nil {
+		return false
+	}
+	rows, err := result.RowsAffected()
+	if err != nil || rows != 1 {
+		return false
+	}
+	return true
+}
+
+// UpdateDeployManifest records the manifest of the deploy request file as JSON. A manifest larger than the
+// column is not recorded, as cutting it would not be valid JSON.
+func (d *DBConnect) UpdateDeployManifest(domain string, environment string, name string, manifest string) bool {
+	if len(manifest) > MaxManifestLength {
+		return false
+	}
+	result, err := d.db.Exec("UPDATE artifactory_deploys "+
+		"SET manifest = ?, "+
+		"updated_at = NOW() "+
+		"WHERE domain = ? AND environment = ? AND service_name = ?",
+		manifest, domain, environment, name)
+	if err != nil {
+		return false
+	}
+	rows, err := result.RowsAffected()
+	if err != nil || rows != 1 {
+		return false
+	}
+	return true
+}
+
+// UpdateDeployUnits records the service units of the deploy and their deploy ids as JSON. Units larger than
+// the column are not recorded, as cutting them would not be valid JSON.
+func (d *DBConnect) UpdateDeployUnits(domain string, environment string, name string, units string) bool {
+	if len(units) > MaxUnitsLength {
+		return false
+	}
+	result, err := d.db.Exec("UPDATE artifactory_deploys "+
+		"SET units = ?, "+
+		"updated_at = NOW() "+
+		"WHERE domain = ? AND environment = ? AND service_name = ?",
+		units, domain, environment, name)
+	if err != nil {
+		return false
+	}
+	rows, err := result.RowsAffected()
+	if err != nil || rows != 1 {
+		return false
+	}
+	return true
+}
+
+// InsertDeployEvent records the start of a deploy or rollback from the previous version.
+func (d *DBConnect) InsertDeployEvent(domain string, environment string, name string, version string,
+	previousVersion string, event string) bool {
+	result, err := d.db.Exec("INSERT INTO artifactory_deploy_events (domain, environment, service_name, version, "+
+		"previous_version, event, created_at) VALUES (?, ?, ?, ?, ?, ?, NOW())",
+		domain, environment, name, version, previousVersion, event)
 	if err != nil {
 		return false
 	}
@@ -95,6 +192,10 @@ type DeployStatus struct {
 	Name        string `json:"name"`        // The application name of the service ex: video-mobile.
 	Version     string `json:"version"`     // The version of the application ex; 1.0.0-32
 	Status      int    `json:"status"`      // The status ID of the result.
+	Checksum    string `json:"checksum"`    // The verified SHA-256 of the payload.
+	Message     string `json:"message"`     // Why the deploy failed, if it did.
+	Manifest    string `json:"manifest"`    // The manifest of the deploy request file as JSON, if any.
+	Units       string `json:"units"`       // The service units deployed and their deploy ids as JSON.
 	UpdatedAt   string `json:"updatedAt"`   // The create date and time of the deploy.
 	CreatedAt   string `json:"createdAt"`   // The last update to this record.
 }
@@ -102,9 +203,12 @@ type DeployStatus struct {
 // QueryDeploy returns the status of a deploy request.
 func (d *DBConnect) QueryDeployByName(domain string, environment string, name string) (*DeployStatus, error) {
 	r := &DeployStatus{}
-	row := d.db.QueryRow("SELECT deploy_id, domain, environment, service_name, version, status, updated_at, created_at "+
-		"FROM artifactory_deploys WHERE service_name = ?", name)
-	err := row.Scan(&r.DeployID, &r.Domain, &r.Environment, &r.Name, &r.Version, &r.Status, &r.UpdatedAt, &r.CreatedAt)
+	row := d.db.QueryRow("SELECT deploy_id, domain, environment, service_name, version, status, checksum, "+
+		"message, manifest, units, updated_at, created_at "+
+		"FROM artifactory_deploys WHERE domain = ? AND environment = ? AND service_name = ?",
+		domain, environment, name)
+	err := row.Scan(&r.DeployID, &r.Domain, &r.Environment, &r.Name, &r.Version, &r.Status, &r.Checksum,
+		&r.Message, &r.Manifest, &r.Units, &r.UpdatedAt, &r.CreatedAt)
 	switch {
 	case err == sql.ErrNoRows:
 		return nil, err
@@ -115,6 +219,11 @@ func (d *DBConnect) QueryDeployByName(domain string, environment string, name st
 	}
 }
 
+// Ping verifies the connection to the DB is alive.
+func (d *DBConnect) Ping() error {
+	return d.db.Ping()
+}
+
 // Close closes the connection(s) to the DB.
 func (d *DBConnect) Close() bool {
 	d.db.Close()
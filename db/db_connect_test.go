@@ -0,0 +1,68 @@
+package db
+
+import (
+	"database/sql"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// testSchema is the artifactory_deploys table of schema.sql in the SQLite dialect.
+const testSchema = `CREATE TABLE artifactory_deploys (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  deploy_id varchar(255) NOT NULL DEFAULT '',
+  domain varchar(255) NOT NULL,
+  environment varchar(255) NOT NULL,
+  service_name varchar(255) NOT NULL,
+  version varchar(255) NOT NULL,
+  status int(11) NOT NULL DEFAULT '1',
+  checksum varchar(64) NOT NULL DEFAULT '',
+  message varchar(1024) NOT NULL DEFAULT '',
+  manifest varchar(2048) NOT NULL DEFAULT '',
+  units varchar(2048) NOT NULL DEFAULT '',
+  updated_at datetime NOT NULL,
+  created_at datetime NOT NULL,
+  UNIQUE (domain, environment, service_name)
+)`
+
+func TestQueryDeployByName(t *testing.T) {
+	d, err := NewDBConnectDriver("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	defer d.Close()
+	d.db.SetMaxOpenConns(1) // Every connection to :memory: is a new database.
+	if _, err := d.db.Exec(testSchema); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	rows := []struct {
+		domain, environment, name, version string
+		status                             int
+	}{
+		{"example.com", "development", "video-mobile", "1.0.1-23", Success},
+		{"example.com", "production", "video-mobile", "1.0.1-21", Failed},
+		{"example.org", "development", "video-mobile", "2.0.0-1", Started},
+	}
+	for _, r := range rows {
+		if _, err := d.db.Exec("INSERT INTO artifactory_deploys (domain, environment, service_name, version, "+
+			"status, updated_at, created_at) VALUES (?, ?, ?, ?, ?, '2015-09-01 10:00:00', '2015-09-01 10:00:00')",
+			r.domain, r.environment, r.name, r.version, r.status); err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+	}
+
+	for _, r := range rows {
+		st, err := d.QueryDeployByName(r.domain, r.environment, r.name)
+		if err != nil {
+			t.Errorf("%s/%s/%s: unexpected error: %s", r.domain, r.environment, r.name, err.Error())
+			continue
+		}
+		if st.Domain != r.domain || st.Environment != r.environment || st.Version != r.version ||
+			st.Status != r.status {
+			t.Errorf("%s/%s/%s: unexpected deploy %+v", r.domain, r.environment, r.name, st)
+		}
+	}
+	if _, err := d.QueryDeployByName("example.com", "qa", "video-mobile"); err != sql.ErrNoRows {
+		t.Errorf("Expected no rows for an environment without deploys, received %v.", err)
+	}
+}
@@ -49,12 +49,38 @@ CREATE TABLE `artifactory_deploys` (
   `service_name` varchar(255) NOT NULL COMMENT 'The service name being deployed, for example acme-video-mobile',
   `version` varchar(255) NOT NULL COMMENT 'The version of the service being deployed e.g. 1.0.2',
   `status` int(11) NOT NULL DEFAULT '1' COMMENT 'The current status of the deploy: Started, Failed, Success.',
+  `checksum` varchar(64) NOT NULL DEFAULT '' COMMENT 'The verified SHA-256 checksum of the payload deployed.',
+  `message` varchar(1024) NOT NULL DEFAULT '' COMMENT 'The reason the deploy failed, if it did.',
+  `manifest` varchar(2048) NOT NULL DEFAULT '' COMMENT 'The manifest of the deploy request file as JSON, if any.',
+  `units` varchar(2048) NOT NULL DEFAULT '' COMMENT 'The service units deployed and their deploy ids as JSON.',
   `updated_at` datetime NOT NULL COMMENT 'The update date and time of the deploy.',
   `created_at` datetime NOT NULL COMMENT 'The create date and time of the deploy.',
   PRIMARY KEY (`id`),
   UNIQUE KEY `key_UNIQUE` (`domain`, `environment`, `service_name`)
 ) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
 /*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Table structure for table `artifactory_deploy_events`
+--
+
+DROP TABLE IF EXISTS `artifactory_deploy_events`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+/* note event: deploy, rollback */;
+CREATE TABLE `artifactory_deploy_events` (
+  `id` int(11) NOT NULL AUTO_INCREMENT COMMENT 'The unique identifier for each row.',
+  `domain` varchar(255) NOT NULL COMMENT 'The domain that is being covered by this deploy.',
+  `environment` varchar(255) NOT NULL COMMENT 'The environment that this deploy is being performed.',
+  `service_name` varchar(255) NOT NULL COMMENT 'The service name being deployed, for example acme-video-mobile',
+  `version` varchar(255) NOT NULL COMMENT 'The version of the service being deployed e.g. 1.0.2',
+  `previous_version` varchar(255) NOT NULL DEFAULT '' COMMENT 'The version deployed before this event, if any.',
+  `event` varchar(32) NOT NULL COMMENT 'The type of event: deploy or rollback.',
+  `created_at` datetime NOT NULL COMMENT 'The create date and time of the event.',
+  PRIMARY KEY (`id`),
+  KEY `key_INDEX` (`domain`, `environment`, `service_name`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
 /*!40103 SET TIME_ZONE=@OLD_TIME_ZONE */;
 
 /*!40101 SET SQL_MODE=@OLD_SQL_MODE */;
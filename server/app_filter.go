@@ -0,0 +1,107 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// AppFilter selects the applications of the deploy repo managed by this monitor. Patterns are globs
+// (ex: video-*) or, with a "re:" prefix, regular expressions matching the whole application name.
+type AppFilter struct {
+	Include  []string      `json:"include"` // If not empty, only applications matching one of these are managed.
+	Exclude  []string      `json:"exclude"` // Applications matching one of these are not managed.
+	includes []*appPattern // The compiled include patterns.
+	excludes []*appPattern // The compiled exclude patterns.
+}
+
+// newAppFilter is a factory function that returns a filter of the include and exclude patterns.
+func newAppFilter(include []string, exclude []string) (*AppFilter, error) {
+	f := &AppFilter{Include: include, Exclude: exclude}
+	if err := f.compile(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// loadAppFilter reads the include and exclude patterns of a JSON filter file and adds them to the patterns given.
+func loadAppFilter(filePath string, include []string, exclude []string) (*AppFilter, error) {
+	b, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read filter file %s: %s", filePath, err.Error())
+	}
+	ff := &AppFilter{}
+	if err := json.Unmarshal(b, ff); err != nil {
+		return nil, fmt.Errorf("Invalid filter file %s: %s", filePath, err.Error())
+	}
+	f, err := newAppFilter(append(append([]string{}, include...), ff.Include...),
+		append(append([]string{}, exclude...), ff.Exclude...))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid filter file %s: %s", filePath, err.Error())
+	}
+	return f, nil
+}
+
+// compile compiles the include and exclude patterns.
+func (f *AppFilter) compile() error {
+	var err error
+	if f.includes, err = compileAppPatterns(f.Include); err != nil {
+		return err
+	}
+	f.excludes, err = compileAppPatterns(f.Exclude)
+	return err
+}
+
+// allows returns true if the application is managed by this monitor. A nil filter allows every application.
+func (f *AppFilter) allows(app string) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.includes) > 0 && !matchesAppPattern(f.includes, app) {
+		return false
+	}
+	return !matchesAppPattern(f.excludes, app)
+}
+
+// appPattern is a compiled application pattern.
+type appPattern struct {
+	glob string         // The glob pattern, if not a regular expression.
+	re   *regexp.Regexp // The regular expression, if the pattern has the "re:" prefix.
+}
+
+// compileAppPatterns validates glob patterns and compiles "re:" patterns to match the whole application name.
+func compileAppPatterns(patterns []string) ([]*appPattern, error) {
+	result := make([]*appPattern, 0, len(patterns))
+	for _, p := range patterns {
+		if strings.HasPrefix(p, appFilterRegexPrefix) {
+			re, err := regexp.Compile(fmt.Sprintf("^(?:%s)$", strings.TrimPrefix(p, appFilterRegexPrefix)))
+			if err != nil {
+				return nil, fmt.Errorf("Invalid application pattern %q: %s", p, err.Error())
+			}
+			result = append(result, &appPattern{re: re})
+			continue
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("Invalid application pattern %q: %s", p, err.Error())
+		}
+		result = append(result, &appPattern{glob: p})
+	}
+	return result, nil
+}
+
+// matchesAppPattern returns true if the application matches one of the patterns.
+func matchesAppPattern(patterns []*appPattern, app string) bool {
+	for _, p := range patterns {
+		if p.re != nil {
+			if p.re.MatchString(app) {
+				return true
+			}
+		} else if ok, _ := path.Match(p.glob, app); ok {
+			return true
+		}
+	}
+	return false
+}
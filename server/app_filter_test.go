@@ -0,0 +1,45 @@
+package server
+
+import "testing"
+
+func TestAppFilter(t *testing.T) {
+	tests := []struct {
+		include []string
+		exclude []string
+		app     string
+		allows  bool
+	}{
+		{nil, nil, "video-mobile", true},
+		{[]string{"video-*"}, nil, "video-mobile", true},
+		{[]string{"video-*"}, nil, "search-api", false},
+		{[]string{"video-?eb"}, nil, "video-web", true},
+		{[]string{"video-[mw]*"}, nil, "video-transcoder", false},
+		{nil, []string{"*-legacy"}, "search-legacy", false},
+		{nil, []string{"*-legacy"}, "search-api", true},
+		{[]string{"video-*"}, []string{"video-web"}, "video-web", false},
+		{[]string{"re:(video|search)-.+"}, nil, "search-api", true},
+		{[]string{"re:video"}, nil, "video-mobile", false}, // Regular expressions match the whole name.
+		{[]string{"re:video-(web|mobile)"}, nil, "video-mobile", true},
+	}
+	for _, tc := range tests {
+		f, err := newAppFilter(tc.include, tc.exclude)
+		if err != nil {
+			t.Errorf("Unexpected error: %s", err.Error())
+			continue
+		}
+		if actual := f.allows(tc.app); actual != tc.allows {
+			t.Errorf("Include %v exclude %v of %s: expected %t, received %t.", tc.include, tc.exclude, tc.app,
+				tc.allows, actual)
+		}
+	}
+
+	var none *AppFilter
+	if !none.allows("video-mobile") {
+		t.Errorf("A nil filter should allow every application.")
+	}
+	for _, pattern := range []string{"video-[", "re:video-(", "re:*"} {
+		if _, err := newAppFilter([]string{pattern}, nil); err == nil {
+			t.Errorf("Expected an error compiling %q.", pattern)
+		}
+	}
+}
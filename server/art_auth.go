@@ -0,0 +1,162 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// artAuthenticator adds credentials to requests sent to the Artifactory API.
+type artAuthenticator interface {
+	Authorize(req *http.Request) error
+}
+
+// newArtAuthenticator is a factory function that returns the authenticator for the auth mode in the options.
+func newArtAuthenticator(o *Options, c *httpClient) artAuthenticator {
+	switch o.ArtAuthMode {
+	case ArtAuthAPIKey:
+		return &artAPIKeyAuth{key: o.ArtAPIKey}
+	case ArtAuthToken:
+		return newArtTokenAuth(o.ArtAccessToken, o.ArtRefreshToken,
+			fmt.Sprintf("%s%s", o.ArtAPIEndpoint, artTokenRoute), c)
+	default:
+		return &artBasicAuth{userID: o.ArtUserID, password: o.ArtPassword}
+	}
+}
+
+// artBasicAuth authenticates with a user id and password.
+type artBasicAuth struct {
+	userID   string
+	password string
+}
+
+// Authorize is an implementation of the artAuthenticator interface.
+func (a *artBasicAuth) Authorize(req *http.Request) error {
+	req.SetBasicAuth(a.userID, a.password)
+	return nil
+}
+
+// artAPIKeyAuth authenticates with an Artifactory API key.
+type artAPIKeyAuth struct {
+	key string
+}
+
+// Authorize is an implementation of the artAuthenticator interface.
+func (a *artAPIKeyAuth) Authorize(req *http.Request) error {
+	req.Header.Set(artAPIKeyHeader, a.key)
+	return nil
+}
+
+// artTokenAuth authenticates with a bearer access token. If a refresh token is given, the access token
+// is refreshed shortly before it expires.
+type artTokenAuth struct {
+	mu           sync.Mutex
+	accessToken  string      // The current access token.
+	refreshToken string      // The token used to obtain a new access token.
+	expires      time.Time   // When the access token expires. Zero if unknown or never.
+	tokenURL     string      // The Artifactory token API endpoint.
+	client       *httpClient // The client used to refresh the token.
+}
+
+// newArtTokenAuth is a factory function that returns a token authenticator. The expiry is read from the
+// access token if it is a JWT.
+func newArtTokenAuth(accessToken string, refreshToken string, tokenURL string, c *httpClient) *artTokenAuth {
+	return &artTokenAuth{
+		accessToken:  accessToken,
+		refreshToken: refreshToken,
+		expires:      jwtExpiry(accessToken),
+		tokenURL:     tokenURL,
+		client:       c,
+	}
+}
+
+// Authorize is an implementation of the artAuthenticator interface.
+func (a *artTokenAuth) Authorize(req *http.Request) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.expires.IsZero() && time.Now().Add(artTokenRefreshWindow).After(a.expires) {
+		if err := a.refresh(); err != nil {
+			if time.Now().After(a.expires) {
+				return fmt.Errorf("Artifactory access token expired and could not be refreshed: %s", err.Error())
+			}
+			a.client.log.Warningf("Cannot refresh artifactory access token: %s", err.Error())
+		}
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", a.accessToken))
+	return nil
+}
+
+// refresh replaces the access token using the refresh token.
+func (a *artTokenAuth) refresh() error {
+	if a.refreshToken == "" {
+		return errors.New("No refresh token.")
+	}
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", a.refreshToken)
+	form.Set("access_token", a.accessToken)
+	req, err := http.NewRequest(httpPost, a.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if err := artResponseError(resp, body); err != nil {
+		return err
+	}
+	result := struct {
+		AccessToken  string `json:"access_token"`  // The new access token.
+		RefreshToken string `json:"refresh_token"` // The new refresh token, if rotated.
+		ExpiresIn    int    `json:"expires_in"`    // Seconds until the new access token expires.
+	}{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return err
+	}
+	if result.AccessToken == "" {
+		return errors.New("No access token returned.")
+	}
+	a.accessToken = result.AccessToken
+	if result.RefreshToken != "" {
+		a.refreshToken = result.RefreshToken
+	}
+	a.expires = jwtExpiry(result.AccessToken)
+	if result.ExpiresIn > 0 {
+		a.expires = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
+	}
+	return nil
+}
+
+// jwtExpiry returns the "exp" claim of a JWT. The zero time is returned if the token is not a JWT
+// or has no expiry.
+func jwtExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}
+	}
+	claims := struct {
+		Exp int64 `json:"exp"`
+	}{}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.Exp, 0)
+}
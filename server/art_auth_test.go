@@ -0,0 +1,79 @@
+package server
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// makeJWT returns an unsigned JWT with the given expiry for testing.
+func makeJWT(exp time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"sub":"monitor","exp":%d}`, exp.Unix())))
+	return fmt.Sprintf("eyJhbGciOiJSUzI1NiJ9.%s.c2lnbmF0dXJl", payload)
+}
+
+func TestArtAuthenticators(t *testing.T) {
+	tests := []struct {
+		opts   *Options
+		header string
+		value  string
+	}{
+		{&Options{ArtAuthMode: ArtAuthBasic, ArtUserID: "sysadm", ArtPassword: "letmein"}, "Authorization",
+			"Basic c3lzYWRtOmxldG1laW4="},
+		{&Options{ArtAuthMode: ArtAuthAPIKey, ArtAPIKey: "AKCp2V"}, artAPIKeyHeader, "AKCp2V"},
+		{&Options{ArtAuthMode: ArtAuthToken, ArtAccessToken: "opaque"}, "Authorization", "Bearer opaque"},
+	}
+	for _, tc := range tests {
+		req, _ := http.NewRequest(httpGet, "http://localhost/api/storage/repo", nil)
+		if err := newArtAuthenticator(tc.opts, newTestHTTPClient(0)).Authorize(req); err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.opts.ArtAuthMode, err.Error())
+		}
+		if actual := req.Header.Get(tc.header); actual != tc.value {
+			t.Errorf("%s: expected %s header %q, received %q.", tc.opts.ArtAuthMode, tc.header, tc.value, actual)
+		}
+	}
+}
+
+func TestArtTokenAuthRefresh(t *testing.T) {
+	var refreshes int32
+	newToken := makeJWT(time.Now().Add(time.Hour))
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.URL.Path != "/api"+artTokenRoute || r.Form.Get("grant_type") != "refresh_token" ||
+			r.Form.Get("refresh_token") != "r3fr3sh" {
+			http.Error(w, `{"errors":[{"status":400,"message":"Bad refresh"}]}`, http.StatusBadRequest)
+			return
+		}
+		atomic.AddInt32(&refreshes, 1)
+		fmt.Fprintf(w, `{"access_token":"%s","expires_in":3600,"refresh_token":"r3fr3sh2"}`, newToken)
+	}))
+	defer ts.Close()
+
+	// A token expiring within the refresh window is refreshed, then reused until it nears expiry.
+	a := newArtTokenAuth(makeJWT(time.Now().Add(10*time.Second)), "r3fr3sh", ts.URL+"/api"+artTokenRoute,
+		newTestHTTPClient(0))
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest(httpGet, ts.URL, nil)
+		if err := a.Authorize(req); err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		if req.Header.Get("Authorization") != "Bearer "+newToken {
+			t.Errorf("Expected the refreshed access token, received %s.", req.Header.Get("Authorization"))
+		}
+	}
+	if refreshes != 1 || a.refreshToken != "r3fr3sh2" {
+		t.Errorf("Expected one refresh and a rotated refresh token, received %d and %s.", refreshes, a.refreshToken)
+	}
+
+	// An expired token that cannot be refreshed is an error.
+	a = newArtTokenAuth(makeJWT(time.Now().Add(-time.Minute)), "wrong", ts.URL+"/api"+artTokenRoute,
+		newTestHTTPClient(0))
+	req, _ := http.NewRequest(httpGet, ts.URL, nil)
+	if err := a.Authorize(req); err == nil {
+		t.Errorf("An expired token that cannot be refreshed should be an error.")
+	}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ArtifactoryError is returned when the Artifactory API responds with a non 2xx status code.
+type ArtifactoryError struct {
+	StatusCode int               `json:"statusCode"` // The HTTP status code of the response.
+	Path       string            `json:"path"`       // The path that was requested.
+	Errors     []*ArtErrorDetail `json:"errors"`     // The errors returned by Artifactory, if any.
+}
+
+// ArtErrorDetail is a single error returned in the body of an Artifactory error response.
+type ArtErrorDetail struct {
+	Status  int    `json:"status"`  // The status code reported for this error.
+	Message string `json:"message"` // The error message.
+}
+
+// Error is an implementation of the error interface.
+func (e *ArtifactoryError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, d := range e.Errors {
+		msgs = append(msgs, d.Message)
+	}
+	msg := fmt.Sprintf("Artifactory API error %d %s for %s", e.StatusCode, http.StatusText(e.StatusCode), e.Path)
+	if len(msgs) > 0 {
+		msg = fmt.Sprintf("%s: %s", msg, strings.Join(msgs, "; "))
+	}
+	return msg
+}
+
+// artResponseError returns an ArtifactoryError if the response status is not 2xx, otherwise nil.
+// The errors[] of a JSON body are captured. Any other body is kept as a single, shortened message.
+func artResponseError(resp *http.Response, body []byte) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	e := &ArtifactoryError{StatusCode: resp.StatusCode}
+	if resp.Request != nil && resp.Request.URL != nil {
+		e.Path = resp.Request.URL.Path
+	}
+	result := struct {
+		Errors []*ArtErrorDetail `json:"errors"`
+	}{}
+	if err := json.Unmarshal(body, &result); err == nil && len(result.Errors) > 0 {
+		e.Errors = result.Errors
+		return e
+	}
+	if msg := strings.TrimSpace(string(body)); msg != "" && !strings.HasPrefix(msg, "<") {
+		if len(msg) > maxArtErrorLength {
+			msg = msg[:maxArtErrorLength] + "..."
+		}
+		e.Errors = []*ArtErrorDetail{{Status: resp.StatusCode, Message: msg}}
+	}
+	return e
+}
@@ -4,44 +4,104 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"path"
+	"sort"
 	"strings"
-	"sync"
 	"time"
 
+	"github.com/composer22/coreos-artifactory-monitor/db"
 	cosddb "github.com/composer22/coreos-deploy/db"
 )
 
-// Monitor is a go routine that continually monitors artifactory for any version changes.
-func (s *Server) Monitor() {
+// Monitor is a go routine that continually monitors artifactory for any version changes of a target.
+// Deploy jobs run in the pipeline of their application, so polling continues on schedule while they run.
+func (s *Server) Monitor(tm *targetMonitor) {
 	s.wg.Add(1)
 	defer s.wg.Done()
 
-	var wg sync.WaitGroup
+	ticker := time.NewTicker(time.Second * time.Duration(s.opts.ArtPollingInterval))
+	defer ticker.Stop()
+	var release <-chan time.Time // Never fires without a schedule.
+	if s.schedule != nil {
+		rt := time.NewTicker(scheduleCheckInterval)
+		defer rt.Stop()
+		release = rt.C
+	}
 	for {
-		timer := time.NewTimer(time.Second * time.Duration(s.opts.ArtPollingInterval))
+		appName := "" // All applications.
 		select {
 		case <-s.done: // Shutdown signal.
-			timer.Stop()
 			return
-		case <-s.force: // Force a check for deltas. Don't wait.
-			timer.Stop()
-		case <-timer.C: // Timeout.
+		case <-tm.force: // Force a check for deltas. Don't wait.
+		case appName = <-tm.check: // Check a single application for deltas. Don't wait.
+		case <-ticker.C: // Timeout.
+		case <-release: // Deploy the held jobs whose window has opened.
+			s.releasePending(tm)
+			continue
 		}
 
 		// Get changes.
-		deploys, err := s.checkDeltas(&wg)
+		deploys, err := s.checkDeltas(tm, appName)
 		if err != nil {
-			s.log.Errorf("Check Deltas Error: %s", err.Error())
+			s.log.Errorf("Check Deltas Error for %s: %s", tm.target.key(), err.Error())
+			tm.polled(err, 0)
+			continue
+		}
+		tm.polled(nil, s.scheduleDeploys(tm, appName, deploys))
+	}
+}
+
+// scheduleDeploys queues the deploys allowed by the schedule in the pipeline of each application.
+// The others are held as pending until a window opens. The number of deploys queued is returned.
+func (s *Server) scheduleDeploys(tm *targetMonitor, appName string, deploys []*DeployWorker) int {
+	held := make(map[string]bool)
+	submitted := 0
+	now := time.Now()
+	for _, d := range deploys {
+		if ok, reason := s.schedule.allowed(tm.target.Environment, d.Name, now); !ok {
+			if tm.pending.hold(d, reason) {
+				s.log.Infof("Deploy of %s %s to %s is pending: %s", d.Name, d.Version, tm.target.key(), reason)
+			}
+			held[d.Name] = true
+			continue
 		}
-		// run the deploys.
-		for _, d := range deploys {
-			go d.Run()
+		s.pipelines.Submit(d)
+		submitted++
+	}
+	tm.pending.prune(appName, held)
+	return submitted
+}
+
+// releasePending queues the pending deploys of a target now allowed by the schedule.
+func (s *Server) releasePending(tm *targetMonitor) {
+	now := time.Now()
+	released := tm.pending.release(func(d *DeployWorker) bool {
+		ok, _ := s.schedule.allowed(tm.target.Environment, d.Name, now)
+		return ok
+	})
+	for _, d := range released {
+		s.log.Infof("Deploy of %s %s to %s released by the schedule.", d.Name, d.Version, tm.target.key())
+		s.pipelines.Submit(d)
+	}
+}
+
+// heldBySchedule moves a job about to run back to the pending deploys of its target if the schedule no longer
+// allows it, as a freeze may have started or a window closed while it waited. True is returned if it was held.
+func (s *Server) heldBySchedule(d *DeployWorker) bool {
+	ok, reason := s.schedule.allowed(d.Target.Environment, d.Name, time.Now())
+	if ok {
+		return false
+	}
+	for _, tm := range s.monitors {
+		if tm.target == d.Target {
+			tm.pending.hold(d, reason)
 		}
-		wg.Wait() // Wait for all deploy jobs to complete before monitoring again.
 	}
+	s.log.Infof("Deploy of %s %s to %s is pending: %s", d.Name, d.Version, d.Target.key(), reason)
+	return true
 }
 
 // ArtFolderInfo is returned from a call to collect folder info from an API request.
@@ -54,99 +114,363 @@ type ArtFolderInfo struct {
 	ModifiedBy   string                `json:"modifiedBy"`   // Who modified the folder or file.
 	LastUpdated  string                `json:"lastUpdated"`  // This might be the same as last modified?
 	Children     []*ArtFolderInfoChild `json:"children"`     // This is a list of folders and files in the directory.
+	Checksums    *ArtChecksums         `json:"checksums"`    // The checksums of a file.
 	Uri          string                `json:"uri"`          // The API URL that was called.
 }
 
+// ArtChecksums is returned from a call to collect file info from an API request. See ArtFolderInfo.
+type ArtChecksums struct {
+	Sha1   string `json:"sha1"`   // The SHA-1 checksum of the file.
+	Md5    string `json:"md5"`    // The MD5 checksum of the file.
+	Sha256 string `json:"sha256"` // The SHA-256 checksum of the file, if Artifactory has calculated it.
+}
+
 // ArtFolderInfoChild is returned from a call to collect folder info from an API request. See ArtFolderInfo.
 type ArtFolderInfoChild struct {
 	Uri    string `json:"uri"`    // The subdirectory or file name ex: "/1.0.0-21"
 	Folder bool   `json:"folder"` // If true, this is a subdirectory.
 }
 
+// ArtAQLResult is returned from an Artifactory Query Language search request.
+type ArtAQLResult struct {
+	Results []*ArtAQLItem `json:"results"` // The items found by the search.
+}
+
+// ArtAQLItem is a single item returned from an AQL search. See ArtAQLResult.
+type ArtAQLItem struct {
+	Repo       string `json:"repo"`        // The repository of the item.
+	Path       string `json:"path"`        // The folder path of the item in the repo ex: "video-mobile"
+	Name       string `json:"name"`        // The file name ex: "1.0.1-22.deploy"
+	Created    string `json:"created"`     // When the file was created.
+	ModifiedBy string `json:"modified_by"` // Who last modified the file.
+}
+
 // checkDeltas returns an array of deploy jobs, one for each docker instance who's version has changed in artifactory.
-func (s *Server) checkDeltas(wg *sync.WaitGroup) ([]*DeployWorker, error) {
+// If appName is not empty, only that application is checked. Versions blocked by the property rules are recorded
+// with the monitor of the target, and jobs not started for versions no longer requested are withdrawn.
+func (s *Server) checkDeltas(tm *targetMonitor, appName string) ([]*DeployWorker, error) {
+	t := tm.target
 	jobs := make([]*DeployWorker, 0)
+	blocked := make(map[string][]*BlockedDeployStatus)
+	wanted := make(map[string]map[string]bool) // The requested versions by application name.
 
-	// Get folders names from repo.
-	apps, err := s.getArtFolders(s.opts.ArtDeployRepo, true)
+	// Get the deploy request versions of each application from the repo.
+	deploys, err := s.getDeployVersions(t, appName)
 	if err != nil {
 		return nil, err
 	}
+	appNames := make([]string, 0, len(deploys))
+	for name := range deploys {
+		appNames = append(appNames, name)
+	}
+	sort.Strings(appNames)
 
-	// Check each folder for the latest deploy version and add it to the deploy list if needed.
-	for _, app := range apps {
-		// dir equates as "reponame" + "/appname" => "foorepo/appname"
-		dir := fmt.Sprintf("%s%s", s.opts.ArtDeployRepo, app.Uri)
-		versions, err := s.getArtFolders(dir, false)
-		if err != nil {
-			s.log.Errorf("Unable to read directory %s: %s", dir, err.Error())
+	// Check each application for the latest deploy version and add it to the deploy list if needed.
+	for _, name := range appNames {
+		files, requested, isRequested := requestedVersions(deploys[name])
+		wanted[name] = isRequested
+		if len(requested) == 0 {
 			continue
 		}
-		// Find the latest version tag for this application.
-		var latestVersion string = ""
-		for _, iv := range versions {
-			if iv.Uri > latestVersion {
-				latestVersion = iv.Uri
-			}
-		}
-		appName := strings.Replace(app.Uri, "/", "", 1)
-		latestVersion = strings.Replace(latestVersion, "/", "", 1)
-		latestVersion = strings.Replace(latestVersion, ".deploy", "", 1)
+		less := versionOrdering(s.opts.versionOrder(name), files)
 
 		// Check the last version deployed from the database.
-		lastDep, err := s.db.QueryDeployByName(s.opts.Domain, s.opts.Environment, appName)
+		lastDep, err := s.db.QueryDeployByName(t.Domain, t.Environment, name)
 		if err != nil && err != sql.ErrNoRows {
-			s.log.Errorf("Unable to read deploy from db for %s-%s-%s: %s", s.opts.Domain,
-				s.opts.Environment, appName, err.Error())
+			s.log.Errorf("Unable to read deploy from db for %s-%s-%s: %s", t.Domain,
+				t.Environment, name, err.Error())
 			continue
 		}
-		// If no version has been deployed, or it's out of date, or it failed before then create a new job.
-		if err != nil || lastDep.Version < latestVersion ||
-			(lastDep.Version == latestVersion && lastDep.Status == cosddb.Failed) {
-			jobs = append(jobs, NewDeployWorker(appName, latestVersion, s.opts, s.log, s.db, wg))
+
+		// Try the versions still requested from the latest down, until one needs no deploy or one is not blocked.
+		required := s.properties.required(t.Environment, name)
+		sort.Sort(newestFirst{requested, less})
+		for _, v := range requested {
+			d := s.deltaJob(t, name, v, lastDep, files, isRequested, less)
+			if d == nil {
+				break
+			}
+			if len(required) > 0 {
+				props, err := s.getArtPayloadProperties(t, name, v.Tag)
+				if err != nil {
+					s.log.Errorf("Unable to read payload properties for %s-%s-%s %s: %s", t.Domain,
+						t.Environment, name, v.Tag, err.Error())
+					break
+				}
+				if missing := missingProperties(required, props); len(missing) > 0 {
+					blocked[name] = append(blocked[name], &BlockedDeployStatus{
+						Domain:      t.Domain,
+						Environment: t.Environment,
+						Name:        name,
+						Version:     v.Tag,
+						Missing:     missing,
+						CheckedAt:   time.Now(),
+					})
+					continue
+				}
+			}
+			jobs = append(jobs, d)
+			break
 		}
 	}
+	tm.blocked.update(appName, blocked)
+	s.pipelines.Withdraw(t, appName, wanted)
 	return jobs, nil
 }
 
-// getArtFolders retrieves a list from the Artifactory directory path contents.
-func (s *Server) getArtFolders(subdir string, retrieveFolders bool) ([]*ArtFolderInfoChild, error) {
-	results := make([]*ArtFolderInfoChild, 0)
-	// evaluates as "http://art.com/foo/api" + "/storage" + "/" + "sub/directory"
-	req, err := http.NewRequest(httpGet, fmt.Sprintf("%s%s/%s/", s.opts.ArtAPIEndpoint, artSourceRoute, subdir), nil)
+// deltaJob returns a deploy job if a requested version needs to be deployed, otherwise nil. lastDep is the last
+// deploy of the application, or nil if it has never been deployed.
+func (s *Server) deltaJob(t *Target, name string, v *DeployVersion, lastDep *db.DeployStatus,
+	files []*DeployVersion, isRequested map[string]bool, less versionLess) *DeployWorker {
+	// If no version has been deployed then create a new job.
+	if lastDep == nil {
+		return NewDeployWorker(t, name, v.Tag, s)
+	}
+	// If the deployed version was removed or marked for rollback then go back to a version still requested.
+	if lastDep.Version != v.Tag && !isRequested[lastDep.Version] && isOlderVersion(v, lastDep.Version, files, less) {
+		d := NewDeployWorker(t, name, v.Tag, s)
+		d.PreviousVersion = lastDep.Version
+		d.Rollback = true
+		return d
+	}
+	// If it's out of date, or it failed before then create a new job.
+	if isNewerVersion(v, lastDep.Version, files, less) ||
+		(lastDep.Version == v.Tag && lastDep.Status == cosddb.Failed) {
+		d := NewDeployWorker(t, name, v.Tag, s)
+		d.PreviousVersion = lastDep.Version
+		return d
+	}
+	return nil
+}
+
+// getDeployVersions returns the deploy request versions of every application in the deploy repo of a target,
+// keyed by application name. If appName is not empty, only that application is returned. Applications not
+// allowed by the application filter are left out.
+func (s *Server) getDeployVersions(t *Target, appName string) (map[string][]*DeployVersion, error) {
+	if appName != "" && !s.filter.allows(appName) {
+		return make(map[string][]*DeployVersion), nil
+	}
+	var deploys map[string][]*DeployVersion
+	var err error
+	if s.opts.ArtDiscovery == DiscoveryFolders {
+		deploys, err = s.walkArtDeployVersions(t, appName)
+	} else {
+		deploys, err = s.searchArtDeployVersions(t, appName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for name := range deploys {
+		if !s.filter.allows(name) {
+			delete(deploys, name)
+		}
+	}
+	return deploys, nil
+}
+
+// searchArtDeployVersions finds the deploy request files in the deploy repo with a single AQL search.
+func (s *Server) searchArtDeployVersions(t *Target, appName string) (map[string][]*DeployVersion, error) {
+	criteria := map[string]interface{}{
+		"repo": t.ArtDeployRepo,
+		"type": "file",
+		"$or": []map[string]interface{}{
+			{"name": map[string]string{"$match": "*" + deployFileExt}},
+			{"name": map[string]string{"$match": "*" + rollbackFileExt}},
+		},
+	}
+	if appName != "" {
+		criteria["path"] = appName
+	}
+	criteriaJSON, _ := json.Marshal(criteria)
+	query := fmt.Sprintf(`items.find(%s).include("repo","path","name","created","modified_by")`, criteriaJSON)
+	// evaluates as "http://art.com/foo/api" + "/search/aql"
+	req, err := s.newArtRequest(httpPost, fmt.Sprintf("%s%s", s.opts.ArtAPIEndpoint, artAQLRoute),
+		strings.NewReader(query))
 	if err != nil {
 		return nil, err
 	}
-	req.SetBasicAuth(s.opts.ArtUserID, s.opts.ArtPassword)
+	req.Header.Set("Content-Type", "text/plain")
 	resp, err := s.sendRequest(req)
 	if err != nil {
 		return nil, err
 	}
-	var fi ArtFolderInfo
-	err = json.Unmarshal([]byte(resp), &fi)
+	var result ArtAQLResult
+	err = json.Unmarshal([]byte(resp), &result)
 	if err != nil {
 		return nil, err
 	}
 
+	deploys := make(map[string][]*DeployVersion)
+	for _, item := range result.Results {
+		// Only files directly inside an application folder are deploy requests.
+		if item.Path == "" || item.Path == "." || strings.Contains(item.Path, "/") {
+			continue
+		}
+		v := newDeployVersion(item.Name)
+		v.Created = parseArtTime(item.Created)
+		v.ModifiedBy = item.ModifiedBy
+		deploys[item.Path] = append(deploys[item.Path], v)
+	}
+	return deploys, nil
+}
+
+// walkArtDeployVersions finds the deploy request files by listing the deploy repo and then each
+// application folder in turn.
+func (s *Server) walkArtDeployVersions(t *Target, appName string) (map[string][]*DeployVersion, error) {
+	if appName != "" {
+		versions, err := s.getArtDeployVersions(t, appName, s.opts.versionOrder(appName))
+		if err != nil {
+			return nil, err
+		}
+		return map[string][]*DeployVersion{appName: versions}, nil
+	}
+
+	// Get folders names from repo.
+	apps, _, err := s.getArtFolders(t.ArtDeployRepo, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// The cached listings of deleted application folders are dropped.
+	present := make(map[string]bool)
+	for _, app := range apps {
+		present[fmt.Sprintf("%s/%s", t.ArtDeployRepo, strings.Replace(app.Uri, "/", "", 1))] = true
+	}
+	s.folders.prune(t.ArtDeployRepo, present)
+
+	deploys := make(map[string][]*DeployVersion)
+	for _, app := range apps {
+		appName := strings.Replace(app.Uri, "/", "", 1)
+		if !s.filter.allows(appName) {
+			continue
+		}
+		versions, err := s.getArtDeployVersions(t, appName, s.opts.versionOrder(appName))
+		if err != nil {
+			s.log.Errorf("Unable to read directory %s/%s: %s", t.ArtDeployRepo, appName, err.Error())
+			continue
+		}
+		deploys[appName] = versions
+	}
+	return deploys, nil
+}
+
+// getArtDeployVersions returns the deploy request versions found in the folder of an application.
+// The created timestamps are only retrieved when the version ordering may need them. The versions of an
+// unchanged folder are taken from the folder cache.
+func (s *Server) getArtDeployVersions(t *Target, appName string, order string) ([]*DeployVersion, error) {
+	// dir equates as "reponame" + "/" + "appname" => "foorepo/appname"
+	dir := fmt.Sprintf("%s/%s", t.ArtDeployRepo, appName)
+	files, unchanged, err := s.getArtFolders(dir, false)
+	if err != nil {
+		return nil, err
+	}
+	if unchanged {
+		if versions, ok := s.folders.cachedVersions(dir, order); ok {
+			return versions, nil
+		}
+	}
+	versions := make([]*DeployVersion, 0, len(files))
+	needCreated := order == VersionOrderCreated
+	for _, f := range files {
+		v := newDeployVersion(strings.TrimPrefix(f.Uri, "/"))
+		if _, ok := parseVersionTag(v.Tag); !ok && order == VersionOrderSemantic {
+			needCreated = true
+		}
+		versions = append(versions, v)
+	}
+	if !needCreated {
+		s.folders.storeVersions(dir, order, versions)
+		return versions, nil
+	}
+	complete := true
+	for _, v := range versions {
+		filePath := fmt.Sprintf("%s/%s%s", dir, v.Tag, deployFileExt)
+		if v.Rollback {
+			filePath = fmt.Sprintf("%s/%s%s", dir, v.Tag, rollbackFileExt)
+		}
+		fi, err := s.getArtItemInfo(filePath)
+		if err != nil {
+			s.log.Errorf("Unable to read file info %s: %s", filePath, err.Error())
+			complete = false
+			continue
+		}
+		v.Created = parseArtTime(fi.Created)
+		v.ModifiedBy = fi.ModifiedBy
+	}
+	if complete {
+		s.folders.storeVersions(dir, order, versions)
+	}
+	return versions, nil
+}
+
+// getArtFolders retrieves a list from the Artifactory directory path contents. The boolean is true if the
+// directory is unchanged since it was last listed.
+func (s *Server) getArtFolders(subdir string, retrieveFolders bool) ([]*ArtFolderInfoChild, bool, error) {
+	results := make([]*ArtFolderInfoChild, 0)
+	fi, unchanged, err := s.getArtFolderInfo(subdir)
+	if err != nil {
+		return nil, false, err
+	}
+
 	// Retrieve content of subdir.
 	for _, c := range fi.Children {
 		// Filter out files vs folders.
 		if c.Folder != retrieveFolders {
 			continue
 		}
-		// If file check and not a deploy file, continue scan.
-		if !retrieveFolders && path.Ext(c.Uri) != ".deploy" {
+		// If file check and not a deploy file or rollback marker, continue scan.
+		if ext := path.Ext(c.Uri); !retrieveFolders && ext != deployFileExt && ext != rollbackFileExt {
 			continue
 		}
 		results = append(results, c)
 	}
-	return results, nil
+	return results, unchanged, nil
 }
 
-// sendRequest sends a request to a server and returns the result.
+// getArtItemInfo retrieves the storage information of an Artifactory folder or file.
+func (s *Server) getArtItemInfo(itemPath string) (*ArtFolderInfo, error) {
+	// evaluates as "http://art.com/foo/api" + "/storage" + "/" + "sub/directory"
+	req, err := s.newArtRequest(httpGet, fmt.Sprintf("%s%s/%s", s.opts.ArtAPIEndpoint, artSourceRoute, itemPath), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.sendRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	var fi ArtFolderInfo
+	err = json.Unmarshal([]byte(resp), &fi)
+	if err != nil {
+		return nil, err
+	}
+	return &fi, nil
+}
+
+// parseArtTime parses an Artifactory ISO8601 timestamp. The zero time is returned if it is invalid.
+func parseArtTime(t string) time.Time {
+	tm, err := time.Parse(time.RFC3339, t)
+	if err != nil {
+		return time.Time{}
+	}
+	return tm
+}
+
+// newArtRequest returns a new request to Artifactory with the credentials added.
+func (s *Server) newArtRequest(method string, urlStr string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, urlStr, body)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.artAuth.Authorize(req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// sendRequest sends a request to a server and returns the result. A non 2xx response is returned
+// as an ArtifactoryError.
 func (s *Server) sendRequest(req *http.Request) (string, error) {
-	cl := &http.Client{}
-	resp, err := cl.Do(req)
+	resp, err := s.client.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -155,5 +479,9 @@ func (s *Server) sendRequest(req *http.Request) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	if err := artResponseError(resp, body); err != nil {
+		s.incrementArtErrorStats(err)
+		return "", err
+	}
 	return string(body), nil
 }
@@ -0,0 +1,387 @@
+package server
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/composer22/coreos-artifactory-monitor/logger"
+)
+
+const testDeployRepo = "cluster-deploys"
+
+// fakeArtFile is a file stored in the fake artifactory deploy repo.
+type fakeArtFile struct {
+	path       string // The folder of the file ex: "video-mobile"
+	name       string // The file name ex: "1.0.1-22.deploy"
+	created    string // When the file was created.
+	modifiedBy string // Who last modified the file.
+}
+
+// fakeArtifactory is an in-process stand in for the artifactory storage and search APIs.
+type fakeArtifactory struct {
+	mu       sync.Mutex
+	files    []*fakeArtFile
+	props    map[string]map[string][]string // Payload properties by file name.
+	modified map[string]string              // The lastModified reported for folders by path, if any.
+	etags    bool                           // If true, folder listings have ETags and honor If-None-Match.
+	requests map[string]int                 // Request counts by "METHOD /path".
+}
+
+// newFakeArtifactory returns a fake artifactory serving the files in the deploy repo.
+func newFakeArtifactory(files []*fakeArtFile) (*fakeArtifactory, *httptest.Server) {
+	f := &fakeArtifactory{files: files, requests: make(map[string]int)}
+	return f, httptest.NewServer(f)
+}
+
+// requestCount returns the number of requests received for a method and path prefix.
+func (f *fakeArtifactory) requestCount(method string, prefix string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	count := 0
+	for k, v := range f.requests {
+		if strings.HasPrefix(k, fmt.Sprintf("%s %s", method, prefix)) {
+			count += v
+		}
+	}
+	return count
+}
+
+// ServeHTTP implements the http.Handler interface for the fake API routes.
+func (f *fakeArtifactory) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests[fmt.Sprintf("%s %s", r.Method, r.URL.Path)]++
+	f.mu.Unlock()
+
+	storagePrefix := fmt.Sprintf("/api%s/%s", artSourceRoute, testDeployRepo)
+	switch {
+	case r.Method == httpGet && r.URL.RawQuery == "properties":
+		f.serveProperties(w, path.Base(r.URL.Path))
+	case r.Method == httpGet && r.URL.Path == "/api"+artPingRoute:
+		w.Write([]byte("OK"))
+	case r.Method == httpPost && r.URL.Path == "/api"+artAQLRoute:
+		f.serveAQL(w, r)
+	case r.Method == httpGet && strings.HasPrefix(r.URL.Path, storagePrefix):
+		f.serveStorage(w, r, strings.Trim(strings.TrimPrefix(r.URL.Path, storagePrefix), "/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveAQL answers a search for the deploy files in the repo.
+func (f *fakeArtifactory) serveAQL(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	if !strings.Contains(string(body), fmt.Sprintf(`"repo":"%s"`, testDeployRepo)) {
+		http.Error(w, "unknown repo", http.StatusBadRequest)
+		return
+	}
+	result := &ArtAQLResult{Results: make([]*ArtAQLItem, 0)}
+	for _, af := range f.files {
+		if !strings.HasSuffix(af.name, deployFileExt) && !strings.HasSuffix(af.name, rollbackFileExt) {
+			continue
+		}
+		result.Results = append(result.Results, &ArtAQLItem{Repo: testDeployRepo, Path: af.path, Name: af.name,
+			Created: af.created, ModifiedBy: af.modifiedBy})
+	}
+	b, _ := json.Marshal(result)
+	w.Write(b)
+}
+
+// serveProperties answers a properties request of a payload.
+func (f *fakeArtifactory) serveProperties(w http.ResponseWriter, name string) {
+	f.mu.Lock()
+	props, ok := f.props[name]
+	f.mu.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"errors":[{"status":404,"message":"No properties could be found."}]}`))
+		return
+	}
+	b, _ := json.Marshal(&ArtItemProperties{Properties: props})
+	w.Write(b)
+}
+
+// serveStorage answers a folder listing or file info request.
+func (f *fakeArtifactory) serveStorage(w http.ResponseWriter, r *http.Request, itemPath string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fi := &ArtFolderInfo{Repo: testDeployRepo, Path: "/" + itemPath, LastModified: f.modified[itemPath]}
+	seen := make(map[string]bool)
+	for _, af := range f.files {
+		switch {
+		case itemPath == "" && !strings.Contains(af.path, "/"):
+			if !seen[af.path] {
+				fi.Children = append(fi.Children, &ArtFolderInfoChild{Uri: "/" + af.path, Folder: true})
+				seen[af.path] = true
+			}
+		case itemPath == af.path:
+			fi.Children = append(fi.Children, &ArtFolderInfoChild{Uri: "/" + af.name, Folder: false})
+		case itemPath == fmt.Sprintf("%s/%s", af.path, af.name):
+			fi.Created = af.created
+			fi.ModifiedBy = af.modifiedBy
+		}
+	}
+	b, _ := json.Marshal(fi)
+	if f.etags {
+		etag := fmt.Sprintf(`"%x"`, sha1.Sum(b))
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+	}
+	w.Write(b)
+}
+
+// newTestServer returns a server configured against a fake artifactory endpoint.
+func newTestServer(endpoint string, discovery string) *Server {
+	return New(&Options{
+		ArtAPIEndpoint: endpoint + "/api",
+		ArtDeployRepo:  testDeployRepo,
+		ArtDiscovery:   discovery,
+		VersionOrder:   DefaultVersionOrder,
+	}, logger.New(logger.Error, false))
+}
+
+var testDeployFiles = []*fakeArtFile{
+	{"video-mobile", "1.0.9-40.deploy", "2015-09-01T10:00:00.000Z", "jenkins"},
+	{"video-mobile", "1.0.10-3.deploy", "2015-09-02T10:00:00.000Z", "jenkins"},
+	{"video-mobile", "README.md", "2015-09-01T09:00:00.000Z", "admin"},
+	{"video-mobile/archive", "0.9.0-1.deploy", "2015-08-01T10:00:00.000Z", "admin"},
+	{"search-api", "nightly.deploy", "2015-09-03T10:00:00.000-07:00", "ci"},
+	{"search-api", "1.2.0-1.deploy", "2015-09-02T10:00:00.000-07:00", "ci"},
+}
+
+func TestGetDeployVersions(t *testing.T) {
+	for _, discovery := range []string{DiscoveryAQL, DiscoveryFolders} {
+		fake, ts := newFakeArtifactory(testDeployFiles)
+		s := newTestServer(ts.URL, discovery)
+		deploys, err := s.getDeployVersions(s.monitors[0].target, "")
+		ts.Close()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", discovery, err.Error())
+			continue
+		}
+		if len(deploys) != 2 || len(deploys["video-mobile"]) != 2 || len(deploys["search-api"]) != 2 {
+			t.Errorf("%s: unexpected deploy versions found: %v", discovery, deploys)
+			continue
+		}
+		latest := latestDeployVersion(deploys["video-mobile"], semanticLess)
+		if latest.Tag != "1.0.10-3" {
+			t.Errorf("%s: expected latest 1.0.10-3, received %s.", discovery, latest.Tag)
+		}
+		latest = latestDeployVersion(deploys["search-api"],
+			versionOrdering(VersionOrderSemantic, deploys["search-api"]))
+		if latest.Tag != "nightly" || latest.ModifiedBy != "ci" {
+			t.Errorf("%s: expected latest nightly by ci, received %s by %s.", discovery, latest.Tag,
+				latest.ModifiedBy)
+		}
+
+		aqlCount := fake.requestCount(httpPost, "/api"+artAQLRoute)
+		storageCount := fake.requestCount(httpGet, "/api"+artSourceRoute)
+		switch discovery {
+		case DiscoveryAQL:
+			if aqlCount != 1 || storageCount != 0 {
+				t.Errorf("AQL discovery should make one search, received %d searches and %d storage calls.",
+					aqlCount, storageCount)
+			}
+		case DiscoveryFolders:
+			// Repo listing, two application folders and the created dates of search-api, which has
+			// an unparseable tag.
+			if aqlCount != 0 || storageCount != 5 {
+				t.Errorf("Folder discovery made %d searches and %d storage calls.", aqlCount, storageCount)
+			}
+		}
+	}
+}
+
+func TestGetDeployVersionsFiltered(t *testing.T) {
+	for _, discovery := range []string{DiscoveryAQL, DiscoveryFolders} {
+		fake, ts := newFakeArtifactory(testDeployFiles)
+		s := newTestServer(ts.URL, discovery)
+		s.filter, _ = newAppFilter([]string{"video-*", "re:search-(api|web)"}, []string{"search-api"})
+		deploys, err := s.getDeployVersions(s.monitors[0].target, "")
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", discovery, err.Error())
+		} else if len(deploys) != 1 || len(deploys["video-mobile"]) != 2 {
+			t.Errorf("%s: expected only video-mobile, received %v", discovery, deploys)
+		}
+		// The folders of excluded applications are not read.
+		if discovery == DiscoveryFolders && fake.requestCount(httpGet, "/api"+artSourceRoute) != 2 {
+			t.Errorf("Expected 2 storage calls, received %d.", fake.requestCount(httpGet, "/api"+artSourceRoute))
+		}
+		// A check of an excluded application finds nothing without calling artifactory.
+		before := fake.requestCount(httpGet, "") + fake.requestCount(httpPost, "")
+		if deploys, err := s.getDeployVersions(s.monitors[0].target, "search-api"); err != nil || len(deploys) != 0 {
+			t.Errorf("%s: expected no deploys of an excluded application, received %v", discovery, deploys)
+		}
+		if after := fake.requestCount(httpGet, "") + fake.requestCount(httpPost, ""); after != before {
+			t.Errorf("%s: an excluded application should not be requested.", discovery)
+		}
+		ts.Close()
+	}
+}
+
+func TestCheckDeltasRollback(t *testing.T) {
+	tests := []struct {
+		name     string
+		order    string
+		deployed string
+		files    []string
+		version  string // The version of the expected job, if any.
+		rollback bool
+	}{
+		{"up to date", VersionOrderSemantic, "1.0.10-3", []string{"1.0.9-40.deploy", "1.0.10-3.deploy"}, "", false},
+		{"newer", VersionOrderSemantic, "1.0.9-40", []string{"1.0.9-40.deploy", "1.0.10-3.deploy"},
+			"1.0.10-3", false},
+		{"removed", VersionOrderSemantic, "1.0.10-3", []string{"1.0.9-40.deploy"}, "1.0.9-40", true},
+		{"removed created", VersionOrderCreated, "1.0.10-3", []string{"1.0.9-40.deploy"}, "1.0.9-40", true},
+		{"marked", VersionOrderSemantic, "1.0.10-3",
+			[]string{"1.0.9-40.deploy", "1.0.10-3.deploy", "1.0.10-3.rollback"}, "1.0.9-40", true},
+		{"marked created", VersionOrderCreated, "1.0.10-3",
+			[]string{"1.0.9-40.deploy", "1.0.10-3.deploy", "1.0.10-3.rollback"}, "1.0.9-40", true},
+		{"rolled back", VersionOrderSemantic, "1.0.9-40",
+			[]string{"1.0.9-40.deploy", "1.0.10-3.deploy", "1.0.10-3.rollback"}, "", false},
+		{"newer after rollback", VersionOrderSemantic, "1.0.9-40",
+			[]string{"1.0.9-40.deploy", "1.0.10-3.deploy", "1.0.10-3.rollback", "1.0.11-1.deploy"}, "1.0.11-1", false},
+		{"newer removed", VersionOrderSemantic, "1.0.9-40", []string{"1.0.10-3.deploy"}, "1.0.10-3", false},
+	}
+	for i, tc := range tests {
+		files := make([]*fakeArtFile, 0, len(tc.files))
+		for j, name := range tc.files {
+			files = append(files, &fakeArtFile{"video-mobile", name,
+				fmt.Sprintf("2015-09-0%dT10:00:00.000Z", j+1), "jenkins"})
+		}
+		for _, discovery := range []string{DiscoveryAQL, DiscoveryFolders} {
+			_, ts := newFakeArtifactory(files)
+			s := newTestServer(ts.URL, discovery)
+			s.opts.VersionOrder = tc.order
+			d, raw := newTestDB(t, fmt.Sprintf("rollback%d%s", i, discovery))
+			s.db = d
+			target := s.monitors[0].target
+			if _, err := raw.Exec("INSERT INTO artifactory_deploys (domain, environment, service_name, version, "+
+				"status, updated_at, created_at) VALUES (?, ?, 'video-mobile', ?, 2, '2015-09-01 10:00:00', "+
+				"'2015-09-01 10:00:00')", target.Domain, target.Environment, tc.deployed); err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+
+			jobs, err := s.checkDeltas(s.monitors[0], "")
+			switch {
+			case err != nil:
+				t.Errorf("%s %s: unexpected error: %s", tc.name, discovery, err.Error())
+			case tc.version == "" && len(jobs) != 0:
+				t.Errorf("%s %s: expected no jobs, received %s.", tc.name, discovery, jobs[0].Version)
+			case tc.version != "" && len(jobs) != 1:
+				t.Errorf("%s %s: expected one job, received %d.", tc.name, discovery, len(jobs))
+			case tc.version != "" && (jobs[0].Version != tc.version || jobs[0].Rollback != tc.rollback ||
+				jobs[0].PreviousVersion != tc.deployed):
+				t.Errorf("%s %s: unexpected job %s rollback %t from %s.", tc.name, discovery, jobs[0].Version,
+					jobs[0].Rollback, jobs[0].PreviousVersion)
+			}
+			d.Close()
+			raw.Close()
+			ts.Close()
+		}
+	}
+}
+
+func TestCheckDeltasWithdrawQueued(t *testing.T) {
+	for i, marker := range []bool{true, false} {
+		fake, ts := newFakeArtifactory([]*fakeArtFile{
+			{"video-mobile", "1.0.9-40.deploy", "2015-09-01T10:00:00.000Z", "jenkins"},
+			{"video-mobile", "1.0.10-3.deploy", "2015-09-02T10:00:00.000Z", "jenkins"},
+		})
+		s := newTestServer(ts.URL, DiscoveryAQL)
+		d, raw := newTestDB(t, fmt.Sprintf("withdraw%d", i))
+		s.db = d
+		tm := s.monitors[0]
+		if _, err := raw.Exec("INSERT INTO artifactory_deploys (domain, environment, service_name, version, "+
+			"status, updated_at, created_at) VALUES (?, ?, 'video-mobile', '1.0.9-40', 2, '2015-09-01 10:00:00', "+
+			"'2015-09-01 10:00:00')", tm.target.Domain, tm.target.Environment); err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+
+		// A deploy of another application keeps the only slot of the pool, so 1.0.10-3 stays queued.
+		pool := newDeployPool(1)
+		release := make(chan bool)
+		var mu sync.Mutex
+		deployed := make([]string, 0)
+		pool.run = func(d *DeployWorker) {
+			<-release
+			mu.Lock()
+			deployed = append(deployed, d.Name+" "+d.Version)
+			mu.Unlock()
+		}
+		s.pipelines = newDeployPipelines(pool, s.log)
+		s.pipelines.Submit(&DeployWorker{Target: tm.target, Name: "search-api", Version: "1.2.0-1"})
+
+		jobs, err := s.checkDeltas(tm, "")
+		if err != nil || len(jobs) != 1 || jobs[0].Version != "1.0.10-3" {
+			t.Fatalf("Test %d: expected a job for 1.0.10-3, received %d jobs (%v).", i, len(jobs), err)
+		}
+		s.scheduleDeploys(tm, "", jobs)
+
+		// The version is withdrawn while it is queued.
+		fake.mu.Lock()
+		if marker {
+			fake.files = append(fake.files, &fakeArtFile{"video-mobile", "1.0.10-3.rollback",
+				"2015-09-03T10:00:00.000Z", "admin"})
+		} else {
+			fake.files = fake.files[:1]
+		}
+		fake.mu.Unlock()
+		if jobs, err := s.checkDeltas(tm, ""); err != nil || len(jobs) != 0 {
+			t.Errorf("Test %d: expected no jobs, received %d (%v).", i, len(jobs), err)
+		}
+		if st := s.pipelines.status(); len(st) != 1 || st[0].Name != "search-api" {
+			t.Errorf("Test %d: expected the queued deploy of video-mobile to be withdrawn.", i)
+		}
+
+		close(release)
+		pool.Wait()
+		if len(deployed) != 1 || deployed[0] != "search-api 1.2.0-1" {
+			t.Errorf("Test %d: expected only search-api to be deployed, received %v.", i, deployed)
+		}
+		d.Close()
+		raw.Close()
+		ts.Close()
+	}
+}
+
+func TestArtifactoryErrorResponses(t *testing.T) {
+	tests := []struct {
+		status   int
+		body     string
+		expected string
+	}{
+		{http.StatusUnauthorized, `{"errors":[{"status":401,"message":"Bad credentials"}]}`, "Bad credentials"},
+		{http.StatusNotFound, `<html><body>Not Found</body></html>`, "404 Not Found"},
+		{http.StatusBadRequest, `Failed to parse query`, "Failed to parse query"},
+	}
+	for _, tc := range tests {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, tc.body, tc.status)
+		}))
+		s := newTestServer(ts.URL, DiscoveryAQL)
+		_, err := s.getDeployVersions(s.monitors[0].target, "")
+		ts.Close()
+		ae, ok := err.(*ArtifactoryError)
+		if !ok {
+			t.Errorf("Status %d: expected an ArtifactoryError, received %v.", tc.status, err)
+			continue
+		}
+		if ae.StatusCode != tc.status || ae.Path != "/api"+artAQLRoute || !strings.Contains(ae.Error(), tc.expected) {
+			t.Errorf("Status %d: unexpected error: %s", tc.status, ae.Error())
+		}
+		if s.stats.ArtErrors != 1 || s.stats.ArtErrorStats[fmt.Sprintf("%d", tc.status)] != 1 {
+			t.Errorf("Status %d: error stats not incremented: %v", tc.status, s.stats.ArtErrorStats)
+		}
+	}
+}
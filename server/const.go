@@ -10,22 +10,113 @@ const (
 	DefaultProfPort        = 0             // Profiler port to receive requests.*
 	DefaultMaxProcs        = 0             // Maximum number of computer processors to utilize.*
 	DefaultPollingInterval = 300           // Polling interval in seconds to check artifactory (5 min).
+	DefaultVersionOrder    = "semantic"    // How deploy request versions are ordered to find the latest.
+	DefaultDiscovery       = "aql"         // How deploy request files are discovered in artifactory.
+	DefaultArtAuthMode     = "basic"       // How requests to artifactory are authenticated.
+	DefaultMaxDeploys      = 4             // Maximum deploy jobs running at once.*
+	DefaultHTTPTimeout     = 30            // Timeout in seconds of an outbound API request.
+	DefaultDownloadTimeout = 600           // Timeout in seconds of a payload download.*
+	DefaultMaxPayloadSize  = 1024          // Maximum size in MB of a payload download.*
+	DefaultHTTPRetries     = 3             // Maximum retries of an idempotent outbound request.
+	DefaultHTTPBackoff     = 500           // Backoff in milliseconds before the first retry.
+	DefaultHTTPBackoffMax  = 10000         // Maximum backoff in milliseconds between retries.*
+	DefaultHTTPRetryBudget = 60            // Maximum seconds spent on all attempts of a request.*
+	DefaultReadyPolls      = 3             // Polling intervals allowed since the last successful poll when ready.
+
+	// Payload cache.
+	DefaultPayloadCacheDir  = "/tmp/coreos-artifactory-monitor-cache/" // The directory of the payloads cached.
+	DefaultPayloadCacheSize = 2048                                     // Maximum size in MB of the payload cache.*
 
 	// * zeros = no change or no limitations or not enabled.
 
 	// http: routes.
-	httpRouteV1Health  = "/v1.0/health"
-	httpRouteV1Info    = "/v1.0/info"
-	httpRouteV1Metrics = "/v1.0/metrics"
-	httpRouteV1Force   = "/v1.0/force"
+	httpRouteV1Health      = "/v1.0/health"
+	httpRouteV1HealthLive  = "/v1.0/health/live"
+	httpRouteV1HealthReady = "/v1.0/health/ready"
+	httpRouteV1Info        = "/v1.0/info"
+	httpRouteV1Metrics     = "/v1.0/metrics"
+	httpRouteV1Force       = "/v1.0/force"
+	httpRouteV1Targets     = "/v1.0/targets"
+	httpRouteV1Deploys     = "/v1.0/deploys/" // + {domain}/{environment}/{name}
+
+	httpRouteV1PayloadCache = "/v1.0/cache/payloads"
+
+	httpRouteV1ArtWebhook = "/v1.0/webhooks/artifactory"
 
 	// Artifactory API routes
 	artSourceRoute = "/storage"
+	artAQLRoute    = "/search/aql"
+	artTokenRoute  = "/security/token"
+	artPingRoute   = "/system/ping"
+
+	// Artifactory authentication modes.
+	ArtAuthBasic  = "basic"  // User id and password.
+	ArtAuthAPIKey = "apikey" // API key in the X-JFrog-Art-Api header.
+	ArtAuthToken  = "token"  // Bearer access token, refreshed before it expires.
+
+	artAPIKeyHeader       = "X-JFrog-Art-Api"
+	artTokenRefreshWindow = 60 * time.Second // Refresh an access token this long before it expires.
+
+	artChecksumHeader = "X-Checksum-Sha256" // The checksum of a downloaded file.
+	maxArtErrorLength = 256                 // Maximum length of a non JSON error body kept in an error.
+
+	// Payload downloads.
+	downloadPartExt          = ".part"          // The file a payload is streamed to until it is complete.
+	downloadProgressInterval = 10 * time.Second // How often the progress of a payload download is logged.
+
+	// Deploy manifests.
+	maxManifestReason = 512 // Maximum bytes of the reason of a manifest.
+
+	// Payload extraction.
+	maxExtractFileSize = 32 * 1024 * 1024  // Maximum size of a file extracted from a payload.
+	maxExtractSize     = 128 * 1024 * 1024 // Maximum size of all the files extracted from a payload.
+
+	// Discovery modes of deploy request files.
+	DiscoveryAQL     = "aql"     // A single AQL search of the deploy repo.
+	DiscoveryFolders = "folders" // A folder listing of the deploy repo and of each application folder.
+
+	// Deploy request files.
+	deployFileExt   = ".deploy"   // A request to deploy a version ex: 1.0.1-22.deploy
+	rollbackFileExt = ".rollback" // A marker withdrawing a requested version ex: 1.0.1-22.rollback
+
+	// Artifactory webhooks.
+	webhookSignatureHeader = "X-JFrog-Event-Auth" // HMAC-SHA256 hex signature of the payload.
+	webhookDomainArtifact  = "artifact"
+	webhookEventDeployed   = "deployed"
+	webhookEventDeleted    = "deleted"
+	webhookQueueSize       = 64 // Maximum application checks waiting on the monitor.
+
+	maxWebhookBody = 64 * 1024 // Maximum size of a webhook event read before its signature is checked.
+
+	// Payload property rules.
+	propertyAnyValue = "*" // A required property matching any value.
+
+	// Payload signatures.
+	signatureExt            = ".sig" // The detached signature stored next to a payload ex: foo.tar.gz.sig
+	signatureAnyEnvironment = "*"    // Payloads must be signed in every environment.
+
+	maxSignatureSize = 8 * 1024 // Maximum size of a detached payload signature.
+
+	// Application filters.
+	appFilterRegexPrefix = "re:" // Marks an application pattern as a regular expression instead of a glob.
+
+	// Deploy schedule.
+	scheduleCheckInterval = time.Minute // How often pending deploys are checked against the schedule.
+
+	// Version ordering of deploy requests.
+	VersionOrderSemantic = "semantic" // major.minor.patch-build tags, falling back to created timestamps.
+	VersionOrderCreated  = "created"  // Artifactory created timestamps of the deploy request files.
+	VersionOrderLexical  = "lexical"  // Plain string comparison of the tags.
 
 	// Connections.
 	TCPReadTimeout  = 10 * time.Second
 	TCPWriteTimeout = 10 * time.Second
 
+	// Readiness checks.
+	readyCheckTimeout = 5 * time.Second // Timeout of each dependency check request.
+	readyCacheTTL     = 5 * time.Second // How long the result of the dependency checks is reused.
+	deployHealthRoute = "/v1.0/health"  // The health route of the coreos-deploy service.
+
 	httpGet    = "GET"
 	httpPost   = "POST"
 	httpPut    = "PUT"
@@ -39,6 +130,8 @@ const (
 	maxPollStatusCount = 6  // 6 times
 	maxPollStatusPause = 10 // 10 seconds
 
+	deployIDPlaceholder = "00000000-0000-0000-0000-000000000000" // The size of a coreos-deploy deploy id.
+
 	// Error messages.
 	InvalidMediaType     = "Invalid Content-Type or Accept header value."
 	InvalidMethod        = "Invalid Method for this route."
@@ -46,4 +139,10 @@ const (
 	InvalidJSONText      = "Invalid JSON format in text of body in request."
 	InvalidJSONAttribute = "Invalid - 'text' attribute in JSON not found."
 	InvalidAuthorization = "Invalid authorization."
+	InvalidSignature     = "Invalid webhook signature."
+	WebhookQueueFull     = "Too many pending webhook checks."
+	WebhookTooLarge      = "Webhook event too large."
+	InvalidDeployPath    = "Invalid path - expected /v1.0/deploys/{domain}/{environment}/{name}."
+	DeployNotFound       = "Deploy not found."
+	DatabaseError        = "Database error."
 )
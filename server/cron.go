@@ -0,0 +1,123 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSpec is a parsed five field cron expression: minute hour day-of-month month day-of-week.
+// Each field is a bit set of the values it matches.
+type cronSpec struct {
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+	domAny bool // The day of month field is "*".
+	dowAny bool // The day of week field is "*".
+}
+
+// cronField describes the range of values of a cron field.
+type cronField struct {
+	name string
+	min  int
+	max  int
+}
+
+var cronFields = []cronField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7}, // 0 and 7 are Sunday.
+}
+
+// parseCron parses a cron expression. Each field is "*", a value, a range "a-b", or a comma separated list
+// of those, each optionally followed by a step "/n".
+func parseCron(expr string) (*cronSpec, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("Cron expression %q must have %d fields.", expr, len(cronFields))
+	}
+	sets := make([]uint64, len(fields))
+	for i, f := range fields {
+		set, err := parseCronField(f, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("Cron expression %q: %s", expr, err.Error())
+		}
+		sets[i] = set
+	}
+	c := &cronSpec{
+		minute: sets[0],
+		hour:   sets[1],
+		dom:    sets[2],
+		month:  sets[3],
+		dow:    sets[4],
+		domAny: fields[2] == "*",
+		dowAny: fields[4] == "*",
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1 // Sunday.
+	}
+	return c, nil
+}
+
+// parseCronField returns the bit set of the values matched by one field of a cron expression.
+func parseCronField(f string, cf cronField) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(f, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid %s step %q.", cf.name, part)
+			}
+			rng, step = part[:i], n
+		}
+		lo, hi := cf.min, cf.max
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			bounds := strings.SplitN(rng, "-", 2)
+			var err1, err2 error
+			lo, err1 = strconv.Atoi(bounds[0])
+			hi, err2 = strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return 0, fmt.Errorf("invalid %s range %q.", cf.name, part)
+			}
+		default:
+			n, err := strconv.Atoi(rng)
+			if err != nil {
+				return 0, fmt.Errorf("invalid %s value %q.", cf.name, part)
+			}
+			lo, hi = n, n
+			if step > 1 {
+				hi = cf.max // "a/n" is every n from a.
+			}
+		}
+		if lo < cf.min || hi > cf.max || lo > hi {
+			return 0, fmt.Errorf("%s %q is out of range %d-%d.", cf.name, part, cf.min, cf.max)
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+// matches returns true if the minute of t is matched by the expression. As with cron, if both the day of
+// month and the day of week are restricted, a day matching either one matches.
+func (c *cronSpec) matches(t time.Time) bool {
+	if c.minute&(1<<uint(t.Minute())) == 0 || c.hour&(1<<uint(t.Hour())) == 0 ||
+		c.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronMatches(t *testing.T) {
+	tests := []struct {
+		expr    string
+		time    string
+		matches bool
+	}{
+		{"* * * * *", "2026-10-19T03:17:00Z", true},
+		{"0-29 9-16 * * 1-5", "2026-10-19T09:00:00Z", true},  // Monday.
+		{"0-29 9-16 * * 1-5", "2026-10-19T16:29:00Z", true},  // Monday.
+		{"0-29 9-16 * * 1-5", "2026-10-19T16:30:00Z", false}, // Monday.
+		{"0-29 9-16 * * 1-5", "2026-10-18T10:00:00Z", false}, // Sunday.
+		{"*/15 * * * *", "2026-10-19T10:45:00Z", true},
+		{"*/15 * * * *", "2026-10-19T10:46:00Z", false},
+		{"5/20 * * * *", "2026-10-19T10:25:00Z", true},
+		{"0 22 * * 7", "2026-10-18T22:00:00Z", true}, // Sunday as 7.
+		{"0,30 12 1,15 * *", "2026-10-15T12:30:00Z", true},
+		{"* * * 11-12 *", "2026-10-15T12:30:00Z", false},
+		{"* * 1 * 1", "2026-10-19T12:00:00Z", true}, // A Monday that is not the first.
+		{"* * 1 * 1", "2026-10-20T12:00:00Z", false},
+	}
+	for _, tc := range tests {
+		c, err := parseCron(tc.expr)
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %s", tc.expr, err.Error())
+			continue
+		}
+		at, _ := time.Parse(time.RFC3339, tc.time)
+		if actual := c.matches(at); actual != tc.matches {
+			t.Errorf("%q at %s: expected %t, received %t.", tc.expr, tc.time, tc.matches, actual)
+		}
+	}
+}
+
+func TestCronInvalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *",
+		"* * * * 8", "5-1 * * * *", "*/0 * * * *", "a * * * *", "1-b * * * *"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("Expected an error parsing %q.", expr)
+		}
+	}
+}
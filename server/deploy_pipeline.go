@@ -0,0 +1,154 @@
+package server
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/composer22/coreos-artifactory-monitor/logger"
+)
+
+// deployPipelines serializes the deploy jobs of each application of each target. Only one job per application is in the
+// deploy pool at a time. While it is in progress, only the newest version detected waits behind it, and a
+// job that has not started yet is superseded when a newer version is detected.
+type deployPipelines struct {
+	mu   sync.Mutex
+	pool *deployPool             // Runs the jobs.
+	apps map[string]*appPipeline // The pipelines by job key, see DeployWorker.key.
+	log  *logger.Logger          // Log instance for recording superseded jobs.
+}
+
+// appPipeline holds the deploy jobs in progress for one application.
+type appPipeline struct {
+	active *DeployWorker // The job in the deploy pool, queued or running.
+	next   *DeployWorker // The job waiting for the active job to complete.
+}
+
+// AppPipelineStatus describes the deploy jobs in progress for an application.
+type AppPipelineStatus struct {
+	Domain      string           `json:"domain"`      // The domain deployed to.
+	Environment string           `json:"environment"` // The environment deployed to.
+	Name        string           `json:"name"`        // The application name.
+	Active      *DeployJobStatus `json:"active"`      // The job in the deploy pool, queued or running.
+	Next        *DeployJobStatus `json:"next"`        // The job waiting for the active job to complete.
+	key         string           // The job key the status is sorted by.
+}
+
+// newDeployPipelines is a factory function that returns pipelines submitting their jobs to the pool.
+func newDeployPipelines(pool *deployPool, l *logger.Logger) *deployPipelines {
+	p := &deployPipelines{
+		pool: pool,
+		apps: make(map[string]*appPipeline),
+		log:  l,
+	}
+	pool.done = p.completed
+	return p
+}
+
+// Submit adds a deploy job to the pipeline of its application. A job for a version already in the
+// pipeline is ignored.
+func (p *deployPipelines) Submit(d *DeployWorker) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ap, ok := p.apps[d.key()]
+	if !ok {
+		ap = &appPipeline{}
+		p.apps[d.key()] = ap
+	}
+	switch {
+	case ap.active == nil:
+		ap.active = d
+		p.pool.Submit(d)
+	case ap.active.Version == d.Version || (ap.next != nil && ap.next.Version == d.Version):
+		return
+	case p.pool.Remove(ap.active):
+		p.log.Infof("Deploy of %s %s superseded by %s before it started.", d.Name, ap.active.Version, d.Version)
+		ap.active = d
+		p.pool.Submit(d)
+	default:
+		if ap.next != nil {
+			p.log.Infof("Deploy of %s %s superseded by %s before it started.", d.Name, ap.next.Version, d.Version)
+		}
+		ap.next = d
+	}
+}
+
+// Withdraw drops the jobs of a target that have not started and whose version is no longer requested, whether
+// queued in the deploy pool or waiting as the next job. requested holds the requested versions by application
+// name. If appName is not empty, only that application is checked.
+func (p *deployPipelines) Withdraw(t *Target, appName string, requested map[string]map[string]bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, ap := range p.apps {
+		d := ap.active
+		if d.Target != t || (appName != "" && appName != d.Name) {
+			continue
+		}
+		if ap.next != nil && !requested[d.Name][ap.next.Version] {
+			p.log.Infof("Deploy of %s %s withdrawn before it started.", d.Name, ap.next.Version)
+			ap.next = nil
+		}
+		if requested[d.Name][d.Version] || !p.pool.Remove(d) {
+			continue
+		}
+		p.log.Infof("Deploy of %s %s withdrawn before it started.", d.Name, d.Version)
+		ap.active, ap.next = ap.next, nil
+		if ap.active == nil {
+			delete(p.apps, key)
+			continue
+		}
+		p.pool.Submit(ap.active)
+	}
+}
+
+// completed is called by the deploy pool when a job has run. The next job of the application is submitted.
+func (p *deployPipelines) completed(d *DeployWorker) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ap, ok := p.apps[d.key()]
+	if !ok || ap.active != d {
+		return
+	}
+	ap.active, ap.next = ap.next, nil
+	if ap.active == nil {
+		delete(p.apps, d.key())
+		return
+	}
+	p.pool.Submit(ap.active)
+}
+
+// Stop drops every job that has not started so only the running jobs remain in the deploy pool.
+func (p *deployPipelines) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, ap := range p.apps {
+		ap.next = nil
+		if p.pool.Remove(ap.active) {
+			delete(p.apps, key)
+		}
+	}
+}
+
+// status returns a snapshot of the pipelines sorted by domain, environment and application name.
+func (p *deployPipelines) status() []*AppPipelineStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	result := make([]*AppPipelineStatus, 0, len(p.apps))
+	for key, ap := range p.apps {
+		active := ap.active.jobStatus()
+		st := &AppPipelineStatus{Domain: active.Domain, Environment: active.Environment, Name: active.Name,
+			Active: active, key: key}
+		if ap.next != nil {
+			st.Next = ap.next.jobStatus()
+		}
+		result = append(result, st)
+	}
+	sort.Sort(appPipelineStatusByKey(result))
+	return result
+}
+
+// appPipelineStatusByKey sorts pipeline statuses by job key.
+type appPipelineStatusByKey []*AppPipelineStatus
+
+func (a appPipelineStatusByKey) Len() int           { return len(a) }
+func (a appPipelineStatusByKey) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a appPipelineStatusByKey) Less(i, j int) bool { return a[i].key < a[j].key }
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/composer22/coreos-artifactory-monitor/logger"
+)
+
+func TestDeployPipelinesSupersede(t *testing.T) {
+	pool := newDeployPool(1)
+	release := make(chan bool)
+	var mu sync.Mutex
+	deployed := make(map[string][]string)
+	pool.run = func(d *DeployWorker) {
+		<-release
+		mu.Lock()
+		deployed[d.Name] = append(deployed[d.Name], d.Version)
+		mu.Unlock()
+	}
+	p := newDeployPipelines(pool, logger.New(logger.Error, false))
+
+	p.Submit(&DeployWorker{Name: "video-mobile", Version: "1.0.1-1"}) // Runs.
+	p.Submit(&DeployWorker{Name: "search-api", Version: "2.0.0-1"})   // Queued in the pool.
+	p.Submit(&DeployWorker{Name: "search-api", Version: "2.0.0-2"})   // Supersedes 2.0.0-1 in the pool.
+	p.Submit(&DeployWorker{Name: "video-mobile", Version: "1.0.1-2"}) // Waits behind 1.0.1-1.
+	p.Submit(&DeployWorker{Name: "video-mobile", Version: "1.0.1-3"}) // Supersedes 1.0.1-2.
+	p.Submit(&DeployWorker{Name: "video-mobile", Version: "1.0.1-3"}) // Already in the pipeline.
+	p.Submit(&DeployWorker{Name: "video-mobile", Version: "1.0.1-1"}) // Already running.
+
+	st := p.status()
+	if len(st) != 2 || st[0].Name != "search-api" || st[0].Active.Version != "2.0.0-2" || st[0].Next != nil ||
+		st[1].Active.Version != "1.0.1-1" || st[1].Next == nil || st[1].Next.Version != "1.0.1-3" {
+		t.Errorf("Unexpected pipelines before the deploys ran.")
+	}
+	if ps := pool.status(); ps.Running != 1 || ps.QueueDepth != 1 {
+		t.Errorf("Expected 1 running and 1 queued job, received %d and %d.", ps.Running, ps.QueueDepth)
+	}
+
+	close(release)
+	pool.Wait()
+	if v := deployed["video-mobile"]; len(v) != 2 || v[0] != "1.0.1-1" || v[1] != "1.0.1-3" {
+		t.Errorf("Expected video-mobile 1.0.1-1 then 1.0.1-3 to be deployed, received %v.", v)
+	}
+	if v := deployed["search-api"]; len(v) != 1 || v[0] != "2.0.0-2" {
+		t.Errorf("Expected only search-api 2.0.0-2 to be deployed, received %v.", v)
+	}
+	if len(p.status()) != 0 {
+		t.Errorf("Pipelines should be empty once the deploys have run.")
+	}
+}
+
+func TestDeployPipelinesWithdraw(t *testing.T) {
+	pool := newDeployPool(1)
+	release := make(chan bool)
+	var mu sync.Mutex
+	deployed := make(map[string][]string)
+	pool.run = func(d *DeployWorker) {
+		<-release
+		mu.Lock()
+		deployed[d.Name] = append(deployed[d.Name], d.Version)
+		mu.Unlock()
+	}
+	p := newDeployPipelines(pool, logger.New(logger.Error, false))
+	target := &Target{Domain: "example.com", Environment: "production"}
+	other := &Target{Domain: "example.com", Environment: "staging"}
+
+	p.Submit(&DeployWorker{Target: target, Name: "video-mobile", Version: "1.0.1-1"}) // Runs.
+	p.Submit(&DeployWorker{Target: target, Name: "video-mobile", Version: "1.0.1-2"}) // Waits behind 1.0.1-1.
+	p.Submit(&DeployWorker{Target: target, Name: "search-api", Version: "2.0.0-1"})   // Queued in the pool.
+	p.Submit(&DeployWorker{Target: target, Name: "user-api", Version: "3.0.0-1"})     // Queued in the pool.
+	p.Submit(&DeployWorker{Target: other, Name: "search-api", Version: "2.0.0-1"})    // Queued in the pool.
+
+	// 1.0.1-1 is running so is left, and the other target is not checked.
+	p.Withdraw(target, "", map[string]map[string]bool{
+		"search-api": {"1.0.0-1": true},
+		"user-api":   {"3.0.0-1": true},
+	})
+	if ps := pool.status(); ps.Running != 1 || ps.QueueDepth != 2 {
+		t.Errorf("Expected 1 running and 2 queued jobs, received %d and %d.", ps.Running, ps.QueueDepth)
+	}
+	st := p.status()
+	if len(st) != 3 || st[0].Name != "user-api" || st[1].Name != "video-mobile" || st[1].Next != nil ||
+		st[2].Name != "search-api" || st[2].Environment != "staging" {
+		t.Errorf("Unexpected pipelines after the withdrawal.")
+	}
+
+	close(release)
+	pool.Wait()
+	if v := deployed["video-mobile"]; len(v) != 1 || v[0] != "1.0.1-1" {
+		t.Errorf("Expected only video-mobile 1.0.1-1 to be deployed, received %v.", v)
+	}
+	if v := deployed["search-api"]; len(v) != 1 {
+		t.Errorf("Expected only search-api of the other target to be deployed, received %v.", v)
+	}
+	if v := deployed["user-api"]; len(v) != 1 || v[0] != "3.0.0-1" {
+		t.Errorf("Expected user-api 3.0.0-1 to be deployed, received %v.", v)
+	}
+}
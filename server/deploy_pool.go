@@ -0,0 +1,138 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// deployPool runs deploy jobs with a bounded number running at once. Jobs beyond the limit wait in a
+// queue in the order they were submitted.
+type deployPool struct {
+	mu        sync.Mutex
+	wg        sync.WaitGroup         // Synchronize waiting on pending jobs.
+	max       int                    // Maximum jobs running at once. <= 0 is no limit.
+	queue     []*DeployWorker        // Jobs waiting to run.
+	running   map[*DeployWorker]bool // Jobs running now.
+	run       func(d *DeployWorker)  // Performs a job.
+	done      func(d *DeployWorker)  // Called when a job has run, if set.
+	started   int64                  // How many jobs have started.
+	waitTotal time.Duration          // Total time started jobs waited in the queue.
+	waitMax   time.Duration          // Longest time a started job waited in the queue.
+}
+
+// DeployJobStatus describes a queued or running deploy job.
+type DeployJobStatus struct {
+	Domain      string    `json:"domain"`      // The domain to deploy to.
+	Environment string    `json:"environment"` // The environment to deploy to.
+	Name        string    `json:"name"`        // The image name to deploy.
+	Version     string    `json:"version"`     // The version to deploy.
+	Rollback    bool      `json:"rollback"`    // True if the job rolls back a withdrawn version.
+	QueuedAt    time.Time `json:"queuedAt"`    // When the job was submitted.
+	StartedAt   time.Time `json:"startedAt"`   // When the job started running. Zero if queued.
+}
+
+// DeployPoolStatus contains runtime statistics of the deploy pool.
+type DeployPoolStatus struct {
+	MaxConcurrency int                `json:"maxConcurrency"` // Maximum jobs running at once. <= 0 is no limit.
+	QueueDepth     int                `json:"queueDepth"`     // How many jobs are waiting to run.
+	Running        int                `json:"running"`        // How many jobs are running.
+	Started        int64              `json:"started"`        // How many jobs have started.
+	WaitAvgSeconds float64            `json:"waitAvgSeconds"` // Average time started jobs waited to run.
+	WaitMaxSeconds float64            `json:"waitMaxSeconds"` // Longest time a started job waited to run.
+	Queued         []*DeployJobStatus `json:"queued"`         // The jobs waiting to run, in order.
+	RunningJobs    []*DeployJobStatus `json:"runningJobs"`    // The jobs running now.
+}
+
+// newDeployPool is a factory function that returns a deployPool running at most max jobs at once.
+func newDeployPool(max int) *deployPool {
+	return &deployPool{
+		max:     max,
+		queue:   make([]*DeployWorker, 0),
+		running: make(map[*DeployWorker]bool),
+		run:     func(d *DeployWorker) { d.Run() },
+	}
+}
+
+// Submit queues a deploy job. It is started as soon as the running jobs are below the limit.
+func (p *deployPool) Submit(d *DeployWorker) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	d.QueuedAt = time.Now()
+	p.queue = append(p.queue, d)
+	p.wg.Add(1)
+	p.dispatch()
+}
+
+// Remove takes a job out of the queue if it has not started. It returns true if the job was removed.
+func (p *deployPool) Remove(d *DeployWorker) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, q := range p.queue {
+		if q == d {
+			p.queue = append(p.queue[:i], p.queue[i+1:]...)
+			p.wg.Done()
+			return true
+		}
+	}
+	return false
+}
+
+// Wait blocks until every submitted job has completed.
+func (p *deployPool) Wait() {
+	p.wg.Wait()
+}
+
+// dispatch starts queued jobs while there is room. The lock must be held by the caller.
+func (p *deployPool) dispatch() {
+	for len(p.queue) > 0 && (p.max <= 0 || len(p.running) < p.max) {
+		d := p.queue[0]
+		p.queue = p.queue[1:]
+		d.StartedAt = time.Now()
+		wait := d.StartedAt.Sub(d.QueuedAt)
+		p.started++
+		p.waitTotal += wait
+		if wait > p.waitMax {
+			p.waitMax = wait
+		}
+		p.running[d] = true
+		go p.execute(d)
+	}
+}
+
+// execute is a go routine that runs a job and then starts the next queued job.
+func (p *deployPool) execute(d *DeployWorker) {
+	defer p.wg.Done()
+	p.run(d)
+	p.mu.Lock()
+	delete(p.running, d)
+	p.dispatch()
+	p.mu.Unlock()
+	if p.done != nil {
+		p.done(d)
+	}
+}
+
+// status returns a snapshot of the queue and statistics of the pool.
+func (p *deployPool) status() *DeployPoolStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	st := &DeployPoolStatus{
+		MaxConcurrency: p.max,
+		QueueDepth:     len(p.queue),
+		Running:        len(p.running),
+		Started:        p.started,
+		WaitMaxSeconds: p.waitMax.Seconds(),
+		Queued:         make([]*DeployJobStatus, 0, len(p.queue)),
+		RunningJobs:    make([]*DeployJobStatus, 0, len(p.running)),
+	}
+	if p.started > 0 {
+		st.WaitAvgSeconds = p.waitTotal.Seconds() / float64(p.started)
+	}
+	for _, d := range p.queue {
+		st.Queued = append(st.Queued, d.jobStatus())
+	}
+	for d := range p.running {
+		st.RunningJobs = append(st.RunningJobs, d.jobStatus())
+	}
+	return st
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDeployPoolConcurrency(t *testing.T) {
+	p := newDeployPool(2)
+	release := make(chan bool)
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	p.run = func(d *DeployWorker) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		<-release
+		mu.Lock()
+		running--
+		mu.Unlock()
+	}
+
+	for i := 0; i < 5; i++ {
+		p.Submit(&DeployWorker{Name: fmt.Sprintf("app-%d", i), Version: "1.0.0-1"})
+	}
+	st := p.status()
+	if st.Running != 2 || st.QueueDepth != 3 || len(st.Queued) != 3 || st.Queued[0].Name != "app-2" {
+		t.Errorf("Expected 2 running and app-2 first of 3 queued, received %d running and %d queued.",
+			st.Running, st.QueueDepth)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	p.Wait()
+	st = p.status()
+	if maxRunning != 2 || st.Running != 0 || st.QueueDepth != 0 || st.Started != 5 {
+		t.Errorf("Expected at most 2 running and 5 started, received %d and %d.", maxRunning, st.Started)
+	}
+	if st.WaitMaxSeconds <= 0 || st.WaitAvgSeconds <= 0 {
+		t.Errorf("Queued jobs should record their wait time.")
+	}
+}
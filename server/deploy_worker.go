@@ -4,198 +4,431 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 	"os"
-	"os/exec"
-	"path"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/composer22/coreos-artifactory-monitor/db"
 	"github.com/composer22/coreos-artifactory-monitor/logger"
+	"github.com/composer22/coreos-artifactory-monitor/validate"
 	coscl "github.com/composer22/coreos-deploy-client/client"
 	cosddb "github.com/composer22/coreos-deploy/db"
 )
 
 // DeployWorker is a struct used to manage the deploy job to the cluster.
 type DeployWorker struct {
-	Name     string          `json:"name"`     // The image name to deploy.
-	Version  string          `json:"version"`  // The version to deploy.
-	Opts     *Options        `json:"options"`  // Server options.
-	DeployID string          `json:"deployID"` // A UUID returned from the deploy.
-	log      *logger.Logger  `json:"-"`        // Logger for messages.
-	db       *db.DBConnect   `json:"-"`        // Database connection
-	wg       *sync.WaitGroup `json:"-"`        // The wait group.
+	Name            string          `json:"name"`            // The image name to deploy.
+	Version         string          `json:"version"`         // The version to deploy.
+	PreviousVersion string          `json:"previousVersion"` // The version deployed before this job, if any.
+	Rollback        bool            `json:"rollback"`        // True if the previous version was withdrawn.
+	Target          *Target         `json:"-"`               // The domain and environment to deploy to.
+	Opts            *Options        `json:"options"`         // Server options.
+	DeployID        string          `json:"deployID"`        // The UUID returned from the deploy of the first unit.
+	Units           []*DeployUnit   `json:"units"`           // The service units deployed.
+	Checksum        string          `json:"checksum"`        // The verified SHA-256 of the payload.
+	SignedBy        string          `json:"signedBy"`        // The trusted key that signed the payload, if any.
+	Manifest        *DeployManifest `json:"manifest"`        // The manifest of the deploy request file, if any.
+	QueuedAt        time.Time       `json:"queuedAt"`        // When the job was queued to run.
+	StartedAt       time.Time       `json:"startedAt"`       // When the job started running.
+	serv            *Server         `json:"-"`               // The server that created the job.
+	log             *logger.Logger  `json:"-"`               // Logger for messages.
+	db              *db.DBConnect   `json:"-"`               // Database connection
+}
+
+// DeployUnit is a service unit of a payload deployed by a job.
+type DeployUnit struct {
+	Name     string `json:"name"`     // The name of the unit from its metadata.
+	DeployID string `json:"deployID"` // The UUID returned from the deploy of the unit.
+	Status   int    `json:"status"`   // The status of the deploy of the unit: Started, Failed, Success.
 }
 
 // NewDeployWorker is a factory function that returns a DeployWorker instance.
-func NewDeployWorker(name string, version string, o *Options, l *logger.Logger,
-	d *db.DBConnect, w *sync.WaitGroup) *DeployWorker {
+func NewDeployWorker(t *Target, name string, version string, s *Server) *DeployWorker {
 	return &DeployWorker{
 		Name:    name,
 		Version: version,
-		Opts:    o,
-		log:     l,
-		db:      d,
-		wg:      w,
+		Target:  t,
+		Opts:    s.opts,
+		serv:    s,
+		log:     s.log,
+		db:      s.db,
 	}
 }
 
-// Run is a go routine that performs the deploy job actions.
+// Run performs the deploy job actions. See deployPool for running jobs concurrently.
 func (d *DeployWorker) Run() {
-	d.wg.Add(1)
-	defer d.wg.Done()
+	// The schedule is checked again, as the job may have waited in its pipeline or the deploy pool.
+	if d.serv.heldBySchedule(d) {
+		return
+	}
+
 	// Write the start of job record to the DB.
-	d.db.StartDeploy(d.Opts.Domain, d.Opts.Environment, d.Name, d.Version)
+	d.db.StartDeploy(d.Target.Domain, d.Target.Environment, d.Name, d.Version)
+	event := db.EventDeploy
+	if d.Rollback {
+		event = db.EventRollback
+		d.log.Infof("Rolling back %s in %s from %s to %s", d.Name, d.Target.key(), d.PreviousVersion, d.Version)
+	}
+	d.db.InsertDeployEvent(d.Target.Domain, d.Target.Environment, d.Name, d.Version, d.PreviousVersion, event)
+
+	// Get the optional manifest from the deploy request file.
+	manifest, errMsg := d.getManifest()
+	if errMsg != "" {
+		d.failed("", errMsg)
+		return
+	}
+	if manifest != nil {
+		d.Manifest = manifest
+		d.log.Infof("Deploy of %s %s manifest: %s", d.Name, d.Version, manifest)
+		if manifest.Strategy != "" {
+			d.log.Warningf("Deploy of %s %s manifest strategy %s is not supported by coreos-deploy and is only "+
+				"recorded", d.Name, d.Version, manifest.Strategy)
+		}
+		d.db.UpdateDeployManifest(d.Target.Domain, d.Target.Environment, d.Name, manifest.String())
+	}
+	deployURL, ok := d.Target.deployURL(d.manifestCluster())
+	if !ok {
+		d.failed("", fmt.Sprintf("Deploy cluster %s of %s %s is not configured", d.manifestCluster(), d.Name,
+			d.Version))
+		return
+	}
 
 	// Build standard file name ex: foo.com-development-video-mobile-1.0.1-23.tar.gz
-	tarFilePrefix := fmt.Sprintf("%s-%s-%s-%s", d.Opts.Domain, d.Opts.Environment, d.Name, d.Version)
+	tarFilePrefix := fmt.Sprintf("%s-%s-%s-%s", d.Target.Domain, d.Target.Environment, d.Name, d.Version)
 	tarFileName := fmt.Sprintf("%s.tar.gz", tarFilePrefix)
 
 	tarPath := fmt.Sprintf("%s%s/", tmpDir, d.Name)          // evaluates as "/tmp/" + "Appname" => "/tmp/Appname/"
 	tarFilePath := fmt.Sprintf("%s%s", tarPath, tarFileName) // evaluates as "/tmp/Appname/" + "foo.tar.gz" => "/tmp/Appname/foo.tar.gz"
 
 	if err := os.MkdirAll(tarPath, 0744); err != nil {
-		d.log.Errorf("Cannot make tar temp path %s: %s", tarPath, err.Error())
-		d.db.UpdateDeployByName(d.Opts.Domain, d.Opts.Environment, d.Name, "", cosddb.Failed)
+		d.failed("", fmt.Sprintf("Cannot make tar temp path %s: %s", tarPath, err.Error()))
 		return
 	}
-	// Download and untar the assets for this deploy from Artifactory.
-	if errMsg := d.downloadAssets(tarPath, tarFilePath, tarFileName); errMsg != "" {
-		d.log.Errorf(errMsg)
-		d.db.UpdateDeployByName(d.Opts.Domain, d.Opts.Environment, d.Name, "", cosddb.Failed)
-		return
-	}
-
+	// The payload and what was extracted are removed even when the assets are refused.
 	defer os.Remove(tarFilePath)
 	// evaluates as "/tmp/Appname/" + "foo.com-development-video-mobile-1.0.1-23" + "/"
 	untarredPath := fmt.Sprintf("%s%s/", tarPath, tarFilePrefix)
 	defer os.RemoveAll(untarredPath)
 
-	// Get the filenames from the directory:
-	metaFileName, serviceFileName, etcd2FileName := "", "", ""
-	files, _ := ioutil.ReadDir(untarredPath)
-	for _, f := range files {
-		name := path.Base(f.Name())
-		switch path.Ext(name) {
-		case ".json":
-			metaFileName = name
-		case ".service":
-			serviceFileName = name
-		case ".tmpl":
-			serviceFileName = name
-		case ".etcd2":
-			etcd2FileName = name
-		default:
-		}
+	// Download, verify, validate and untar the assets for this deploy from Artifactory.
+	checksum, payload, errMsg := d.downloadAssets(tarPath, tarFilePath, tarFileName)
+	if errMsg != "" {
+		d.failed("", errMsg)
+		return
 	}
+	d.Checksum = checksum
+	d.db.UpdateDeployChecksum(d.Target.Domain, d.Target.Environment, d.Name, checksum)
 
-	// Validate deploy files exist and set paths.
-	if metaFileName == "" {
-		d.log.Errorf("Metadata file not found in %s", tarFilePath)
-		d.db.UpdateDeployByName(d.Opts.Domain, d.Opts.Environment, d.Name, "", cosddb.Failed)
-		return
+	// Check every unit before any is submitted, as coreos-deploy cannot cancel a deploy once it has started.
+	unitOpts := make([]*coscl.Options, 0, len(payload.Units))
+	for _, u := range payload.Units {
+		co, errMsg := d.unitOptions(u, untarredPath, deployURL)
+		if errMsg != "" {
+			d.failed("", errMsg)
+			return
+		}
+		unitOpts = append(unitOpts, co)
 	}
-	if serviceFileName == "" {
-		d.log.Errorf("Service unit file not found in %s", tarFilePath)
-		d.db.UpdateDeployByName(d.Opts.Domain, d.Opts.Environment, d.Name, "", cosddb.Failed)
+	if n := unitsRecordLength(unitOpts); n > db.MaxUnitsLength {
+		d.failed("", fmt.Sprintf("The %d units of %s %s need %d bytes recorded, more than the maximum of %d bytes",
+			len(unitOpts), d.Name, d.Version, n, db.MaxUnitsLength))
 		return
 	}
-	metaFilePath := fmt.Sprintf("%s%s", untarredPath, metaFileName)
-	serviceFilePath := fmt.Sprintf("%s%s", untarredPath, serviceFileName)
-	etcd2FilePath := ""
-	if etcd2FileName != "" {
-		etcd2FilePath = fmt.Sprintf("%s%s", untarredPath, etcd2FileName)
+
+	// Submit a deploy request for each unit of the payload, then wait for all of them. The units are one
+	// deploy: if any unit fails the deploy fails, and a retry deploys every unit again.
+	var failures []string
+	clients := make([]*coscl.Client, 0, len(unitOpts))
+	for _, co := range unitOpts {
+		cl, du, errMsg := d.submitUnit(co)
+		if errMsg != "" {
+			failures = append(failures, errMsg)
+			break
+		}
+		if d.DeployID == "" {
+			d.DeployID = du.DeployID
+		}
+		d.Units = append(d.Units, du)
+		clients = append(clients, cl)
 	}
+	d.recordUnits()
 
-	// Get the metadata from the file.
-	metaData, errMsg := d.getMetaData(metaFilePath)
-	if errMsg != "" {
-		d.log.Errorf(errMsg)
-		d.db.UpdateDeployByName(d.Opts.Domain, d.Opts.Environment, d.Name, "", cosddb.Failed)
+	// Loop check the status of each deploy and wait for the deploys to complete. The units already started
+	// when a later one could not be submitted are waited for too, so their final status is recorded.
+	for i, du := range d.Units {
+		du.Status = cosddb.Success
+		if errMsg := d.submitStatusRequest(clients[i], du.DeployID); errMsg != "" {
+			du.Status = cosddb.Failed
+			failures = append(failures, fmt.Sprintf("Unit %s: %s", du.Name, errMsg))
+		}
+	}
+	d.recordUnits()
+	if len(failures) > 0 {
+		d.failed(d.DeployID, strings.Join(failures, "; "))
 		return
 	}
 
-	// Submit a deploy request to the client library.
+	// Mark the job complete.
+	d.db.UpdateDeployByName(d.Target.Domain, d.Target.Environment, d.Name, d.DeployID, cosddb.Success, "")
+}
+
+// unitOptions returns the options of the deploy request of a unit of the payload extracted in untarredPath,
+// after checking its files can be read.
+func (d *DeployWorker) unitOptions(u *validate.Unit, untarredPath string, deployURL string) (*coscl.Options, string) {
+	metaData := *u.Meta
+	// The manifest can override the number of instances.
+	if d.Manifest != nil && d.Manifest.NumInstances > 0 {
+		metaData.NumInstances = d.Manifest.NumInstances
+	}
 	co := &coscl.Options{
 		Name:             metaData.Name,
 		Version:          metaData.Version,
 		ImageVersion:     metaData.ImageVersion,
 		NumInstances:     metaData.NumInstances,
-		TemplateFilePath: serviceFilePath,
-		Etcd2FilePath:    etcd2FilePath,
-		Token:            d.Opts.DeployToken,
-		Url:              d.Opts.DeployURL,
+		TemplateFilePath: fmt.Sprintf("%s%s", untarredPath, u.ServiceFile),
+		Token:            d.Target.DeployToken,
+		Url:              deployURL,
 		Debug:            false,
 	}
+	if u.Etcd2File != "" {
+		co.Etcd2FilePath = fmt.Sprintf("%s%s", untarredPath, u.Etcd2File)
+	}
+	for _, filePath := range []string{co.TemplateFilePath, co.Etcd2FilePath} {
+		if filePath == "" {
+			continue
+		}
+		f, err := os.Open(filePath)
+		if err != nil {
+			return nil, fmt.Sprintf("Unit %s: cannot read %s: %s", metaData.Name, filePath, err.Error())
+		}
+		f.Close()
+	}
+	return co, ""
+}
 
+// submitUnit submits the deploy request of a unit. The client used, which then requests the status of the
+// deploy, is returned with the started unit.
+func (d *DeployWorker) submitUnit(co *coscl.Options) (*coscl.Client, *DeployUnit, string) {
 	cl := coscl.New(co) // API client
 	deployID, errMsg := d.submitDeployRequest(cl)
 	if errMsg != "" {
-		d.log.Errorf(errMsg)
-		d.db.UpdateDeployByName(d.Opts.Domain, d.Opts.Environment, d.Name, "", cosddb.Failed)
-		return
+		return nil, nil, fmt.Sprintf("Unit %s: %s", co.Name, errMsg)
 	}
 	co.DeployID = deployID
-	d.DeployID = deployID
+	return cl, &DeployUnit{Name: co.Name, DeployID: deployID, Status: cosddb.Started}, ""
+}
+
+// unitsRecordLength returns the size of the JSON recorded for the units once they are deployed, with a UUID
+// deploy id each.
+func unitsRecordLength(unitOpts []*coscl.Options) int {
+	units := make([]*DeployUnit, 0, len(unitOpts))
+	for _, co := range unitOpts {
+		units = append(units, &DeployUnit{Name: co.Name, DeployID: deployIDPlaceholder, Status: cosddb.Started})
+	}
+	b, _ := json.Marshal(units)
+	return len(b)
+}
+
+// recordUnits records the units of the deploy and their deploy ids.
+func (d *DeployWorker) recordUnits() {
+	b, _ := json.Marshal(d.Units)
+	d.db.UpdateDeployUnits(d.Target.Domain, d.Target.Environment, d.Name, string(b))
+}
+
+// key returns the unique name of the application deployed by the job ex: example.com/development/video-mobile
+func (d *DeployWorker) key() string {
+	if d.Target == nil {
+		return d.Name
+	}
+	return fmt.Sprintf("%s/%s", d.Target.key(), d.Name)
+}
+
+// jobStatus returns a description of the job for reporting.
+func (d *DeployWorker) jobStatus() *DeployJobStatus {
+	st := &DeployJobStatus{Name: d.Name, Version: d.Version, Rollback: d.Rollback, QueuedAt: d.QueuedAt,
+		StartedAt: d.StartedAt}
+	if d.Target != nil {
+		st.Domain = d.Target.Domain
+		st.Environment = d.Target.Environment
+	}
+	return st
+}
+
+// failed logs the reason a deploy job failed and records it against the deploy.
+func (d *DeployWorker) failed(deployID string, errMsg string) {
+	d.log.Errorf(errMsg)
+	d.db.UpdateDeployByName(d.Target.Domain, d.Target.Environment, d.Name, deployID, cosddb.Failed, errMsg)
+}
 
-	// Loop check the status of the deploy and wait for the deploy to complete. Timeout 1 minute.
-	errMsg = d.submitStatusRequest(cl, deployID)
+// downloadAssets retrieves, verifies, validates and untars the assets from the Artifactory repository. A payload
+// already in the cache with the published checksum is not retrieved again. The verified SHA-256 checksum of the
+// payload and its validated content are returned.
+func (d *DeployWorker) downloadAssets(tarPath string, tarFilePath string,
+	tarFileName string) (string, *validate.Payload, string) {
+	published, errMsg := d.getPayloadChecksum(tarFileName)
 	if errMsg != "" {
-		d.log.Errorf(errMsg)
-		d.db.UpdateDeployByName(d.Opts.Domain, d.Opts.Environment, d.Name, deployID, cosddb.Failed)
-		return
+		return "", nil, errMsg
 	}
 
-	// Mark the job complete.
-	d.db.UpdateDeployByName(d.Opts.Domain, d.Opts.Environment, d.Name, d.DeployID, cosddb.Success)
+	checksum := published
+	if published != "" && d.serv.payloads.get(published, tarFilePath) {
+		d.log.Infof("Payload %s found in the cache", tarFileName)
+	} else if checksum, errMsg = d.retrievePayload(tarFilePath, tarFileName, published); errMsg != "" {
+		return "", nil, errMsg
+	}
+
+	// Verify the signature of the payload before anything is extracted.
+	if errMsg := d.verifySignature(tarFilePath, tarFileName, checksum); errMsg != "" {
+		return "", nil, errMsg
+	}
+
+	// Validate the layout and metadata before anything is extracted.
+	payload, err := validate.File(tarFilePath)
+	if err != nil {
+		return "", nil, fmt.Sprintf("Invalid payload %s: %s", tarFileName, err.Error())
+	}
+
+	// Keep the verified payload for retries, rollbacks and redeploys.
+	if err := d.serv.payloads.put(checksum, tarFilePath); err != nil {
+		d.log.Errorf("Cannot cache payload %s: %s", tarFileName, err.Error())
+	}
+
+	// Untar the assets.
+	if err := extractTarGz(tarFilePath, tarPath, maxExtractFileSize, maxExtractSize); err != nil {
+		return "", nil, fmt.Sprintf("Cannot untar file %s: %s", tarFilePath, err.Error())
+	}
+	return checksum, payload, ""
 }
 
-// downloadAssets retrieves and untars the assets from the Artifactory repository.
-func (d *DeployWorker) downloadAssets(tarPath string, tarFilePath string, tarFileName string) string {
+// retrievePayload downloads the payload to the file path and verifies it against the checksum published by
+// Artifactory. The SHA-256 checksum of the payload is returned.
+func (d *DeployWorker) retrievePayload(tarFilePath string, tarFileName string, published string) (string, string) {
 	artFilePath := strings.Replace(d.Opts.ArtAPIEndpoint, "/api", "", 1) // No API.
-	httpPath := fmt.Sprintf("%s/%s/%s/%s", artFilePath, d.Opts.ArtPayloadRepo, d.Name, tarFileName)
-	req, err := http.NewRequest(httpGet, httpPath, nil)
+	httpPath := fmt.Sprintf("%s/%s/%s/%s", artFilePath, d.Target.ArtPayloadRepo, d.Name, tarFileName)
+	partFilePath := tarFilePath + downloadPartExt
+	defer os.Remove(partFilePath)
+	checksum, header, err := d.serv.downloadPayload(httpPath, partFilePath)
 	if err != nil {
-		return fmt.Sprintf("Cannot create request for %s: %s", httpPath, err.Error())
+		return "", fmt.Sprintf("Cannot retrieve file for %s: %s", httpPath, err.Error())
 	}
-	req.SetBasicAuth(d.Opts.ArtUserID, d.Opts.ArtPassword)
-	cl := &http.Client{}
-	resp, err := cl.Do(req)
-	if err != nil {
-		return fmt.Sprintf("Cannot retrieve file for %s: %s", httpPath, err.Error())
+
+	// Verify the payload against the checksum published by Artifactory before using it.
+	if published == "" {
+		published = header
 	}
-	body, err := ioutil.ReadAll(resp.Body)
-	defer resp.Body.Close()
+	if published == "" {
+		return "", fmt.Sprintf("No SHA-256 checksum published for file %s", httpPath)
+	}
+	if checksum != published {
+		return "", fmt.Sprintf("Checksum mismatch for file %s: expected %s, received %s", httpPath, published,
+			checksum)
+	}
+
+	if err := os.Rename(partFilePath, tarFilePath); err != nil {
+		return "", fmt.Sprintf("Cannot write file %s: %s", tarFilePath, err.Error())
+	}
+	return checksum, ""
+}
+
+// verifySignature verifies the detached signature stored next to the payload against the trusted keys. A
+// payload without a signature is only refused where signatures are required, but a signature that does not
+// verify is always refused.
+func (d *DeployWorker) verifySignature(tarFilePath string, tarFileName string, checksum string) string {
+	sp := d.serv.signatures
+	if sp == nil {
+		return ""
+	}
+	artFilePath := strings.Replace(d.Opts.ArtAPIEndpoint, "/api", "", 1) // No API.
+	httpPath := fmt.Sprintf("%s/%s/%s/%s%s", artFilePath, d.Target.ArtPayloadRepo, d.Name, tarFileName,
+		signatureExt)
+	sig, err := d.serv.getArtPayloadSignature(httpPath)
 	if err != nil {
-		return fmt.Sprintf("Cannot read body for file %s: %s", httpPath, err.Error())
+		return fmt.Sprintf("Cannot retrieve signature %s: %s", httpPath, err.Error())
 	}
-	err = ioutil.WriteFile(tarFilePath, body, 0644)
+	if sig == nil {
+		if sp.required(d.Target.Environment) {
+			return fmt.Sprintf("No signature %s for file %s, required in %s", httpPath, tarFileName,
+				d.Target.Environment)
+		}
+		return ""
+	}
+	signer, err := sp.verify(tarFilePath, checksum, sig)
 	if err != nil {
-		return fmt.Sprintf("Cannot write file %s: %s", tarFilePath, err.Error())
+		return fmt.Sprintf("Invalid signature %s for file %s: %s", httpPath, tarFileName, err.Error())
 	}
+	d.SignedBy = signer
+	d.log.Infof("Payload %s signed by %s", tarFileName, signer)
+	return ""
+}
 
-	// Untar the assets.
-	cmd := exec.Command("tar", "-xzf", tarFilePath, "-C", tarPath)
-	if _, err := execCmd(cmd); err != nil {
-		return fmt.Sprintf("Cannot untar file %s: %s", tarFilePath, err.Error())
+// getPayloadChecksum returns the SHA-256 checksum of the payload from the Artifactory storage API.
+// An empty checksum is returned if Artifactory has not calculated one.
+func (d *DeployWorker) getPayloadChecksum(tarFileName string) (string, string) {
+	// evaluates as "http://art.com/foo/api" + "/storage" + "/" + "payloadrepo/appname/foo.tar.gz"
+	httpPath := fmt.Sprintf("%s%s/%s/%s/%s", d.Opts.ArtAPIEndpoint, artSourceRoute, d.Target.ArtPayloadRepo,
+		d.Name, tarFileName)
+	req, err := d.serv.newArtRequest(httpGet, httpPath, nil)
+	if err != nil {
+		return "", fmt.Sprintf("Cannot create request for %s: %s", httpPath, err.Error())
 	}
-	return ""
+	resp, err := d.serv.client.Do(req)
+	if err != nil {
+		return "", fmt.Sprintf("Cannot retrieve file info for %s: %s", httpPath, err.Error())
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Sprintf("Cannot read file info for %s: %s", httpPath, err.Error())
+	}
+	if err := artResponseError(resp, body); err != nil {
+		d.serv.incrementArtErrorStats(err)
+		return "", fmt.Sprintf("Cannot retrieve file info for %s: %s", httpPath, err.Error())
+	}
+	var fi ArtFolderInfo
+	if err := json.Unmarshal(body, &fi); err != nil {
+		return "", fmt.Sprintf("Cannot parse file info for %s: %s", httpPath, err.Error())
+	}
+	if fi.Checksums == nil {
+		return "", ""
+	}
+	return strings.ToLower(fi.Checksums.Sha256), ""
 }
 
-// getMetaData returns the metadata from the untarred file for the application assets.
-func (d *DeployWorker) getMetaData(metaFilePath string) (*coscl.ServiceTemplateVars, string) {
-	var metaData coscl.ServiceTemplateVars
-	m, err := ioutil.ReadFile(metaFilePath)
+// getManifest returns the manifest from the deploy request file in the Artifactory repository.
+// A nil manifest is returned if the file is empty.
+func (d *DeployWorker) getManifest() (*DeployManifest, string) {
+	artFilePath := strings.Replace(d.Opts.ArtAPIEndpoint, "/api", "", 1) // No API.
+	httpPath := fmt.Sprintf("%s/%s/%s/%s.deploy", artFilePath, d.Target.ArtDeployRepo, d.Name, d.Version)
+	req, err := d.serv.newArtRequest(httpGet, httpPath, nil)
 	if err != nil {
-		return nil, fmt.Sprintf("Cannot read metadata from %s: %s", metaFilePath, err.Error())
+		return nil, fmt.Sprintf("Cannot create request for %s: %s", httpPath, err.Error())
 	}
-	err = json.Unmarshal(m, &metaData)
+	resp, err := d.serv.client.Do(req)
+	if err != nil {
+		return nil, fmt.Sprintf("Cannot retrieve deploy request for %s: %s", httpPath, err.Error())
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Sprintf("Cannot parse metadata from %s: %s", metaFilePath, err.Error())
+		return nil, fmt.Sprintf("Cannot read deploy request for %s: %s", httpPath, err.Error())
+	}
+	if err := artResponseError(resp, body); err != nil {
+		d.serv.incrementArtErrorStats(err)
+		return nil, fmt.Sprintf("Cannot retrieve deploy request for %s: %s", httpPath, err.Error())
+	}
+	m, err := parseDeployManifest(body)
+	if err != nil {
+		return nil, fmt.Sprintf("Cannot parse deploy request %s: %s", httpPath, err.Error())
+	}
+	return m, ""
+}
+
+// manifestCluster returns the cluster targeted by the manifest, or empty for the default cluster.
+func (d *DeployWorker) manifestCluster() string {
+	if d.Manifest == nil {
+		return ""
 	}
-	return &metaData, ""
+	return d.Manifest.Cluster
 }
 
 // submitDeployRequest returns a unique deploy id after submitting a request via the client library to
@@ -225,7 +458,7 @@ func (d *DeployWorker) submitStatusRequest(cl *coscl.Client, deployID string) st
 		time.Sleep(time.Second * maxPollStatusPause)
 		resp, err := cl.Execute()
 		if err != nil {
-			return fmt.Sprintf("Could not submit status request for deployID %s", deployID, err.Error())
+			return fmt.Sprintf("Could not submit status request for deployID %s: %s", deployID, err.Error())
 		}
 		err = json.Unmarshal([]byte(resp), &stat)
 		if err != nil {
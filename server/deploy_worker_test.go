@@ -0,0 +1,290 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/composer22/coreos-artifactory-monitor/db"
+	"github.com/composer22/coreos-artifactory-monitor/validate"
+	coscl "github.com/composer22/coreos-deploy-client/client"
+	cosddb "github.com/composer22/coreos-deploy/db"
+)
+
+func TestUnitOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "units")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	untarredPath := dir + "/"
+	os.Mkdir(filepath.Join(dir, "web"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "web", "web@.service.tmpl"), []byte("[Unit]"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "web", "web.etcd2"), []byte("k v"), 0644)
+	meta := &coscl.ServiceTemplateVars{Name: "example-web", Version: "1.0.1-22", ImageVersion: "1.0.1-22",
+		NumInstances: 2}
+
+	s := newTestServer("http://127.0.0.1:1", DiscoveryAQL)
+	d := NewDeployWorker(s.monitors[0].target, "web", "1.0.1-22", s)
+	d.Manifest = &DeployManifest{NumInstances: 3}
+	u := &validate.Unit{Dir: "web", MetaFile: "web/meta.json", ServiceFile: "web/web@.service.tmpl",
+		Etcd2File: "web/web.etcd2", Meta: meta}
+	co, errMsg := d.unitOptions(u, untarredPath, "http://coreos.example.com")
+	if errMsg != "" {
+		t.Fatalf("Unexpected error: %s", errMsg)
+	}
+	if co.Name != "example-web" || co.NumInstances != 3 || co.Url != "http://coreos.example.com" ||
+		co.TemplateFilePath != untarredPath+"web/web@.service.tmpl" || co.Etcd2FilePath != untarredPath+"web/web.etcd2" {
+		t.Errorf("Unexpected options: %+v", co)
+	}
+	if meta.NumInstances != 2 {
+		t.Errorf("Expected the unit metadata to be left unchanged, received %d instances.", meta.NumInstances)
+	}
+
+	// A unit with a missing file is refused before anything is submitted.
+	u.Etcd2File = "web/missing.etcd2"
+	if _, errMsg := d.unitOptions(u, untarredPath, "http://coreos.example.com"); !strings.Contains(errMsg,
+		"Unit example-web: cannot read") {
+		t.Errorf("Expected a missing file to be refused, received %q", errMsg)
+	}
+}
+
+func TestUnitsRecordLength(t *testing.T) {
+	unitOpts := []*coscl.Options{{Name: "example-web"}}
+	expected := fmt.Sprintf(`[{"name":"example-web","deployID":"%s","status":%d}]`, deployIDPlaceholder,
+		cosddb.Started)
+	if n := unitsRecordLength(unitOpts); n != len(expected) {
+		t.Errorf("Unexpected units record length %d", n)
+	}
+	for i := 0; i < 30; i++ {
+		unitOpts = append(unitOpts, &coscl.Options{Name: "example-sidecar"})
+	}
+	if n := unitsRecordLength(unitOpts); n <= db.MaxUnitsLength {
+		t.Errorf("Expected 31 units not to fit the units column, received %d bytes", n)
+	}
+}
+
+func TestRunHeldBySchedule(t *testing.T) {
+	sc, err := parseDeploySchedule([]byte(`{"freezes": [{"apps": ["video-mobile"], "start": "2000-01-01T00:00:00Z",
+		"end": "2100-01-01T00:00:00Z", "reason": "Started while queued"}]}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	s := newTestServer("http://localhost", DiscoveryAQL)
+	tm := s.monitors[0]
+
+	// Submitting to the pipeline skips the schedule, as for a job queued before the freeze started.
+	s.schedule = sc
+	s.pipelines.Submit(NewDeployWorker(tm.target, "video-mobile", "1.0.1-1", s))
+	s.deploys.Wait()
+
+	st := tm.pending.status()
+	if len(st) != 1 || st[0].Name != "video-mobile" || st[0].Version != "1.0.1-1" ||
+		!strings.Contains(st[0].Reason, "Started while queued") {
+		t.Errorf("Expected video-mobile 1.0.1-1 to be pending, received %+v.", st)
+	}
+	if len(s.pipelines.status()) != 0 {
+		t.Errorf("Expected the pipelines to be empty once the job was held.")
+	}
+}
+
+// fakePayloadRepo serves a payload, its storage info and an empty deploy request file as artifactory does.
+type fakePayloadRepo struct {
+	data      []byte // The payload served.
+	published string // The SHA-256 of the storage API checksums, if any.
+	header    string // The X-Checksum-Sha256 header of the download, if any.
+}
+
+func (f *fakePayloadRepo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/api"+artSourceRoute+"/"):
+		fi := &ArtFolderInfo{Path: r.URL.Path}
+		if f.published != "" {
+			fi.Checksums = &ArtChecksums{Sha256: f.published}
+		}
+		json.NewEncoder(w).Encode(fi)
+	case strings.HasSuffix(r.URL.Path, deployFileExt):
+	default:
+		if f.header != "" {
+			w.Header().Set(artChecksumHeader, f.header)
+		}
+		w.Write(f.data)
+	}
+}
+
+func TestRetrievePayloadChecksum(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checksum")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	data := []byte("not really a tarball")
+	sum := sha256.Sum256(data)
+	valid := hex.EncodeToString(sum[:])
+	other := strings.Repeat("0", 64)
+
+	tests := []struct {
+		name      string
+		published string
+		header    string
+		expected  string // The error, if any.
+	}{
+		{"published", strings.ToUpper(valid), "", ""},
+		{"header", "", valid, ""},
+		{"published first", valid, other, ""},
+		{"mismatch", other, "", "Checksum mismatch"},
+		{"header mismatch", "", other, "Checksum mismatch"},
+		{"missing", "", "", "No SHA-256 checksum published"},
+	}
+	for _, tc := range tests {
+		ts := httptest.NewServer(&fakePayloadRepo{data: data, published: tc.published, header: tc.header})
+		s := newTestServer(ts.URL, DiscoveryAQL)
+		s.monitors[0].target.ArtPayloadRepo = "cluster-payloads"
+		d := NewDeployWorker(s.monitors[0].target, "video-mobile", "1.0.1-1", s)
+		tarFilePath := filepath.Join(dir, tc.name+".tar.gz")
+
+		published, errMsg := d.getPayloadChecksum("video-mobile-1.0.1-1.tar.gz")
+		checksum := ""
+		if errMsg == "" {
+			checksum, errMsg = d.retrievePayload(tarFilePath, "video-mobile-1.0.1-1.tar.gz", published)
+		}
+		ts.Close()
+
+		if tc.expected == "" {
+			if errMsg != "" || checksum != valid {
+				t.Errorf("%s: expected checksum %s, received %s (%s)", tc.name, valid, checksum, errMsg)
+			}
+			if _, err := os.Stat(tarFilePath); err != nil {
+				t.Errorf("%s: expected the payload to be written: %s", tc.name, err.Error())
+			}
+			continue
+		}
+		if !strings.Contains(errMsg, tc.expected) {
+			t.Errorf("%s: expected error %q, received %q", tc.name, tc.expected, errMsg)
+		}
+		for _, filePath := range []string{tarFilePath, tarFilePath + downloadPartExt} {
+			if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+				t.Errorf("%s: expected %s to be deleted", tc.name, filePath)
+			}
+		}
+	}
+}
+
+func TestRunChecksumMismatch(t *testing.T) {
+	ts := httptest.NewServer(&fakePayloadRepo{data: []byte("tampered"), published: strings.Repeat("0", 64)})
+	defer ts.Close()
+	s := newTestServer(ts.URL, DiscoveryAQL)
+	d, raw := newTestDB(t, "checksum")
+	defer raw.Close()
+	defer d.Close()
+	s.db = d
+	target := s.monitors[0].target
+	target.ArtPayloadRepo = "cluster-payloads"
+	if _, err := raw.Exec("INSERT INTO artifactory_deploys (domain, environment, service_name, version, "+
+		"status, updated_at, created_at) VALUES (?, ?, 'checksum-test', '1.0.1-1', 2, "+
+		"'2015-09-01 10:00:00', '2015-09-01 10:00:00')", target.Domain, target.Environment); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	os.RemoveAll(tmpDir + "checksum-test")
+	NewDeployWorker(target, "checksum-test", "1.0.1-2", s).Run()
+
+	st, err := d.QueryDeployByName(target.Domain, target.Environment, "checksum-test")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if st.Status != cosddb.Failed || !strings.Contains(st.Message, "Checksum mismatch") || st.Checksum != "" {
+		t.Errorf("Expected the refusal to be recorded, received status %d checksum %q: %s", st.Status,
+			st.Checksum, st.Message)
+	}
+	files, _ := ioutil.ReadDir(tmpDir + "checksum-test")
+	if len(files) != 0 {
+		t.Errorf("Expected no files left in the work directory, received %d.", len(files))
+	}
+}
+
+// escapingPayload returns the example payload with a symlink escaping the work directory appended.
+func escapingPayload(t *testing.T) []byte {
+	f, err := os.Open("../example.com-development-video-mobile-1.0.1-22.tar.gz")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		tw.WriteHeader(hdr)
+		io.Copy(tw, tr)
+	}
+	tw.WriteHeader(&tar.Header{Name: "example.com-development-video-mobile-1.0.1-22/leak", Typeflag: tar.TypeSymlink,
+		Linkname: "../../../../etc/passwd", Mode: 0777})
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestRunRejectedPayloadCleanup(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{"invalid", []byte("not a tarball"), "Invalid payload"},
+		{"escaping symlink", escapingPayload(t), "Cannot untar"},
+	}
+	for i, tc := range tests {
+		sum := sha256.Sum256(tc.data)
+		ts := httptest.NewServer(&fakePayloadRepo{data: tc.data, published: hex.EncodeToString(sum[:])})
+		s := newTestServer(ts.URL, DiscoveryAQL)
+		d, raw := newTestDB(t, fmt.Sprintf("cleanup%d", i))
+		s.db = d
+		target := s.monitors[0].target
+		target.Domain, target.Environment, target.ArtPayloadRepo = "example.com", "development", "cluster-payloads"
+		if _, err := raw.Exec("INSERT INTO artifactory_deploys (domain, environment, service_name, version, "+
+			"status, updated_at, created_at) VALUES (?, ?, 'video-mobile', '1.0.1-21', 2, "+
+			"'2015-09-01 10:00:00', '2015-09-01 10:00:00')", target.Domain, target.Environment); err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+
+		os.RemoveAll(tmpDir + "video-mobile")
+		NewDeployWorker(target, "video-mobile", "1.0.1-22", s).Run()
+		ts.Close()
+
+		if st, err := d.QueryDeployByName(target.Domain, target.Environment, "video-mobile"); err != nil ||
+			st.Status != cosddb.Failed || !strings.Contains(st.Message, tc.expected) {
+			t.Errorf("%s: expected the deploy to fail with %q, received %+v (%v)", tc.name, tc.expected, st, err)
+		}
+		files, _ := ioutil.ReadDir(tmpDir + "video-mobile")
+		if len(files) != 0 {
+			t.Errorf("%s: expected the work directory to be empty, received %s.", tc.name, files[0].Name())
+		}
+		d.Close()
+		raw.Close()
+	}
+}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// payloadDownload streams a payload from artifactory to a file. If the transfer is interrupted it is resumed
+// with a range request, or started over if artifactory does not honor the range.
+type payloadDownload struct {
+	serv     *Server       // The server making the download.
+	url      string        // The url of the payload.
+	file     *os.File      // The file written.
+	hash     hash.Hash     // The SHA-256 of the bytes written.
+	maxSize  int64         // The maximum size of the payload in bytes. <= 0 is no limit.
+	timeout  time.Duration // The timeout of each attempt.
+	written  int64         // How many bytes have been written.
+	total    int64         // The size of the payload, or -1 if unknown.
+	checksum string        // The checksum header of the response, if any.
+	lastLog  time.Time     // When the progress was last logged.
+}
+
+// downloadPayload streams the payload at the url to a file. The SHA-256 hex of the file and the checksum
+// header returned by artifactory are returned.
+func (s *Server) downloadPayload(url string, filePath string) (string, string, error) {
+	f, err := os.Create(filePath)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+	pd := &payloadDownload{
+		serv:    s,
+		url:     url,
+		file:    f,
+		hash:    sha256.New(),
+		maxSize: int64(s.opts.MaxPayloadSize) * 1024 * 1024,
+		timeout: time.Duration(s.opts.DownloadTimeout) * time.Second,
+		total:   -1,
+		lastLog: time.Now(),
+	}
+	// Each attempt is sent once and retried here, up to the retries of the client. The retry budget is spent
+	// from when the download last received bytes, so an endpoint that is down gives up in time while a long
+	// download that was progressing can still be resumed.
+	progressAt := time.Now()
+	for attempt := 0; ; attempt++ {
+		written := pd.written
+		err := pd.attempt()
+		if err == nil {
+			break
+		}
+		if pd.written > written {
+			progressAt = time.Now()
+		}
+		if _, ok := err.(*downloadError); ok || attempt >= s.client.retries {
+			return "", "", err
+		}
+		pause := s.client.backoffPause(attempt)
+		if s.client.budget > 0 && time.Since(progressAt)+pause > s.client.budget {
+			return "", "", err
+		}
+		s.log.Warningf("Resuming download of %s at %d bytes in %s: %s", url, pd.written, pause, err.Error())
+		time.Sleep(pause)
+	}
+	s.log.Infof("Downloaded %s: %d bytes", url, pd.written)
+	return hex.EncodeToString(pd.hash.Sum(nil)), pd.checksum, nil
+}
+
+// downloadError is an error that a download cannot recover from by resuming.
+type downloadError struct {
+	msg string
+}
+
+// Error is an implementation of the error interface.
+func (e *downloadError) Error() string {
+	return e.msg
+}
+
+// attempt requests the rest of the payload once and writes it to the file. An error other than a
+// downloadError means the download was interrupted, or artifactory is unavailable, and can be resumed.
+func (pd *payloadDownload) attempt() error {
+	req, err := pd.serv.newArtRequest(httpGet, pd.url, nil)
+	if err != nil {
+		return &downloadError{err.Error()}
+	}
+	if pd.written > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", pd.written))
+	}
+	resp, err := pd.serv.client.DoOnce(req, pd.timeout)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && pd.written > 0:
+		start, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != pd.written {
+			return &downloadError{fmt.Sprintf("Invalid Content-Range %q resuming at %d bytes",
+				resp.Header.Get("Content-Range"), pd.written)}
+		}
+		pd.total = total
+	case resp.StatusCode == http.StatusOK:
+		// The whole payload is sent, so any part already written is discarded.
+		if err := pd.restart(); err != nil {
+			return &downloadError{err.Error()}
+		}
+		pd.total = resp.ContentLength
+	default:
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxArtErrorLength))
+		err := artResponseError(resp, body)
+		if err == nil {
+			err = fmt.Errorf("Unexpected status %d", resp.StatusCode)
+		}
+		pd.serv.incrementArtErrorStats(err)
+		if retryableResponse(resp, nil) {
+			return err
+		}
+		return &downloadError{err.Error()}
+	}
+	if h := resp.Header.Get(artChecksumHeader); h != "" {
+		pd.checksum = strings.ToLower(h)
+	}
+	if pd.maxSize > 0 && pd.total > pd.maxSize {
+		return &downloadError{fmt.Sprintf("Payload size %d bytes exceeds the maximum of %d bytes", pd.total,
+			pd.maxSize)}
+	}
+
+	if err := pd.copy(resp.Body); err != nil {
+		return err
+	}
+	if pd.total >= 0 && pd.written != pd.total {
+		return fmt.Errorf("Received %d of %d bytes", pd.written, pd.total)
+	}
+	return nil
+}
+
+// copy writes the body to the file and the hash, enforcing the maximum size and logging the progress.
+func (pd *payloadDownload) copy(body io.Reader) error {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if pd.maxSize > 0 && pd.written+int64(n) > pd.maxSize {
+				return &downloadError{fmt.Sprintf("Payload exceeds the maximum of %d bytes", pd.maxSize)}
+			}
+			if _, werr := pd.file.Write(buf[:n]); werr != nil {
+				return &downloadError{werr.Error()}
+			}
+			pd.hash.Write(buf[:n])
+			pd.written += int64(n)
+			if time.Since(pd.lastLog) >= downloadProgressInterval {
+				pd.lastLog = time.Now()
+				pd.serv.log.Infof("Downloading %s: %d of %d bytes", pd.url, pd.written, pd.total)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// restart discards the part of the payload already written.
+func (pd *payloadDownload) restart() error {
+	if pd.written == 0 {
+		return nil
+	}
+	if _, err := pd.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := pd.file.Truncate(0); err != nil {
+		return err
+	}
+	pd.hash.Reset()
+	pd.written = 0
+	return nil
+}
+
+// parseContentRange returns the first byte and the total size of a Content-Range header
+// ex: "bytes 100-999/1000". The total is -1 if it is unknown.
+func parseContentRange(header string) (int64, int64, bool) {
+	if !strings.HasPrefix(header, "bytes ") {
+		return 0, 0, false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(header, "bytes "), "/", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	span := strings.SplitN(parts[0], "-", 2)
+	start, err := strconv.ParseInt(span[0], 10, 64)
+	if err != nil || len(span) != 2 {
+		return 0, 0, false
+	}
+	if parts[1] == "*" {
+		return start, -1, true
+	}
+	total, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return start, total, true
+}
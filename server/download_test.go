@@ -0,0 +1,151 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePayload serves a payload, optionally unavailable at first or cutting the connection during the first
+// response.
+type fakePayload struct {
+	mu       sync.Mutex
+	data     []byte   // The payload served.
+	cutAt    int      // Cut the first response after this many bytes of the payload, if > 0.
+	ranges   bool     // Honor range requests.
+	chunked  bool     // Send no Content-Length.
+	down     int      // Answer this many first requests 503 Service Unavailable.
+	requests []string // The Range header of each request.
+}
+
+func (f *fakePayload) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests = append(f.requests, r.Header.Get("Range"))
+	first := len(f.requests) == 1
+	down := len(f.requests) <= f.down
+	f.mu.Unlock()
+	if down {
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	start := 0
+	status := http.StatusOK
+	if rng := r.Header.Get("Range"); f.ranges && strings.HasPrefix(rng, "bytes=") {
+		start, _ = strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rng, "bytes="), "-"))
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(f.data)-1, len(f.data)))
+		status = http.StatusPartialContent
+	}
+	body := f.data[start:]
+	if !f.chunked {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	w.WriteHeader(status)
+	if first && f.cutAt > 0 {
+		w.Write(body[:f.cutAt])
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	w.Write(body)
+}
+
+func TestDownloadPayload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "download")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	data := make([]byte, 3*1024*1024+17)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	sum := sha256.Sum256(data)
+	expected := hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name     string
+		payload  *fakePayload
+		maxSize  int
+		retries  int
+		budget   time.Duration
+		ok       bool
+		requests []string
+	}{
+		{"whole", &fakePayload{}, 0, 0, 0, true, []string{""}},
+		{"chunked", &fakePayload{chunked: true}, 0, 0, 0, true, []string{""}},
+		{"resumed", &fakePayload{cutAt: 1024 * 1024, ranges: true}, 0, 1, 0, true, []string{"", "bytes=1048576-"}},
+		{"restarted", &fakePayload{cutAt: 1024 * 1024}, 0, 1, 0, true, []string{"", "bytes=1048576-"}},
+		{"interrupted", &fakePayload{cutAt: 1024 * 1024, ranges: true}, 0, 0, 0, false, []string{""}},
+		{"too large", &fakePayload{}, 2, 1, 0, false, []string{""}},
+		{"too large chunked", &fakePayload{chunked: true}, 2, 1, 0, false, []string{""}},
+		{"within limit", &fakePayload{}, 4, 0, 0, true, []string{""}},
+		{"unavailable", &fakePayload{down: 1}, 0, 1, 0, true, []string{"", ""}},
+		{"down", &fakePayload{down: 100}, 0, 2, 0, false, []string{"", "", ""}},
+		{"over budget", &fakePayload{down: 100}, 0, 5, time.Nanosecond, false, []string{""}},
+	}
+	for _, tc := range tests {
+		tc.payload.data = data
+		ts := httptest.NewServer(tc.payload)
+		s := newTestServer(ts.URL, DiscoveryAQL)
+		s.opts.MaxPayloadSize = tc.maxSize
+		s.client.retries = tc.retries
+		s.client.budget = tc.budget
+		s.client.backoff = time.Millisecond
+		filePath := filepath.Join(dir, tc.name)
+		checksum, _, err := s.downloadPayload(ts.URL+"/payload.tar.gz", filePath)
+		ts.Close()
+
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: expected success %t, received %v", tc.name, tc.ok, err)
+			continue
+		}
+		tc.payload.mu.Lock()
+		requests := tc.payload.requests
+		tc.payload.mu.Unlock()
+		if strings.Join(requests, ",") != strings.Join(tc.requests, ",") {
+			t.Errorf("%s: expected requests %q, received %q", tc.name, tc.requests, requests)
+		}
+		if !tc.ok {
+			continue
+		}
+		if checksum != expected {
+			t.Errorf("%s: expected checksum %s, received %s", tc.name, expected, checksum)
+		}
+		if b, err := ioutil.ReadFile(filePath); err != nil || len(b) != len(data) {
+			t.Errorf("%s: expected %d bytes written, received %d (%v)", tc.name, len(data), len(b), err)
+		}
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		header string
+		start  int64
+		total  int64
+		ok     bool
+	}{
+		{"bytes 100-999/1000", 100, 1000, true},
+		{"bytes 0-9/*", 0, -1, true},
+		{"bytes */1000", 0, 0, false},
+		{"items 0-9/10", 0, 0, false},
+		{"bytes 10-", 0, 0, false},
+	}
+	for _, tc := range tests {
+		start, total, ok := parseContentRange(tc.header)
+		if ok != tc.ok || start != tc.start || total != tc.total {
+			t.Errorf("%q: expected %d %d %t, received %d %d %t", tc.header, tc.start, tc.total, tc.ok, start,
+				total, ok)
+		}
+	}
+}
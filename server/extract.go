@@ -0,0 +1,176 @@
+package server
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// extractTarGz extracts a tar.gz archive into a directory. Entries with absolute paths or paths outside
+// the directory, symlinks not resolving to a file or directory within it, entries written through a symlink,
+// files larger than maxFileSize and archives larger than maxTotalSize once extracted are rejected. Sizes <= 0
+// are no limit.
+func extractTarGz(archivePath string, dir string, maxFileSize int64, maxTotalSize int64) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(gz)
+	var total int64
+	var links []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return checkSymlinks(root, links)
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		target, err := extractPath(root, hdr.Name)
+		if err != nil {
+			return err
+		}
+		if err := checkNoSymlinks(root, target); err != nil {
+			return err
+		}
+
+		if target == root && hdr.Typeflag != tar.TypeDir {
+			return fmt.Errorf("Entry %s is not a directory", hdr.Name)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if maxFileSize > 0 && hdr.Size > maxFileSize {
+				return fmt.Errorf("Entry %s of %d bytes exceeds the maximum of %d bytes", hdr.Name, hdr.Size,
+					maxFileSize)
+			}
+			total += hdr.Size
+			if maxTotalSize > 0 && total > maxTotalSize {
+				return fmt.Errorf("Archive exceeds the maximum of %d bytes extracted", maxTotalSize)
+			}
+			if err := extractFile(tr, target, hdr); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if filepath.IsAbs(hdr.Linkname) {
+				return fmt.Errorf("Symlink %s to absolute path %s", hdr.Name, hdr.Linkname)
+			}
+			if !withinDir(root, filepath.Join(filepath.Dir(target), hdr.Linkname)) {
+				return fmt.Errorf("Symlink %s to %s is outside of the archive", hdr.Name, hdr.Linkname)
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+			links = append(links, target)
+		default:
+			return fmt.Errorf("Entry %s has unsupported type %q", hdr.Name, hdr.Typeflag)
+		}
+	}
+}
+
+// extractFile writes a regular file entry of the archive.
+func extractFile(r io.Reader, target string, hdr *tar.Header) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, hdr.FileInfo().Mode().Perm()|0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(f, r, hdr.Size); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// extractPath returns the path an entry of the archive is extracted to, or an error if the entry is
+// absolute or outside of the root.
+func extractPath(root string, name string) (string, error) {
+	if filepath.IsAbs(name) || strings.HasPrefix(name, "/") {
+		return "", fmt.Errorf("Entry %s has an absolute path", name)
+	}
+	target := filepath.Join(root, name)
+	if !withinDir(root, target) {
+		return "", fmt.Errorf("Entry %s is outside of the archive", name)
+	}
+	return target, nil
+}
+
+// withinDir returns true if the cleaned path is the directory or below it.
+func withinDir(dir string, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// checkSymlinks returns an error if a symlink extracted does not resolve within the root. Its link name is
+// only checked lexically when it is extracted, so links through other symlinks ex: d/../../x with d -> ../..
+// are caught once every entry of the archive is on disk.
+func checkSymlinks(root string, links []string) error {
+	if len(links) == 0 {
+		return nil
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		resolved, err := filepath.EvalSymlinks(link)
+		if err != nil {
+			return fmt.Errorf("Symlink %s does not resolve: %s", link, err.Error())
+		}
+		if !withinDir(realRoot, resolved) {
+			return fmt.Errorf("Symlink %s resolves outside of the archive", link)
+		}
+	}
+	return nil
+}
+
+// checkNoSymlinks returns an error if the path, or any directory between the root and the path, is an
+// existing symlink, so no entry is written through a link.
+func checkNoSymlinks(root string, target string) error {
+	rel, err := filepath.Rel(root, target)
+	if err != nil {
+		return err
+	}
+	path := root
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		path = filepath.Join(path, part)
+		fi, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("Entry %s is written through the symlink %s", rel, path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testTarEntry is an entry of a crafted archive.
+type testTarEntry struct {
+	name     string
+	typeflag byte
+	body     string
+	linkname string
+}
+
+// writeTestTarGz writes a tar.gz archive of the entries to a file in the directory and returns its path.
+func writeTestTarGz(t *testing.T, dir string, entries []testTarEntry) string {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typeflag, Linkname: e.linkname, Mode: 0644}
+		switch e.typeflag {
+		case tar.TypeDir:
+			hdr.Mode = 0755
+		case tar.TypeReg:
+			hdr.Size = int64(len(e.body))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		tw.Write([]byte(e.body))
+	}
+	tw.Close()
+	gz.Close()
+	filePath := filepath.Join(dir, "payload.tar.gz")
+	if err := ioutil.WriteFile(filePath, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	return filePath
+}
+
+func TestExtractTarGz(t *testing.T) {
+	const app = "example.com-development-video-mobile-1.0.1-22/"
+	tests := []struct {
+		name    string
+		entries []testTarEntry
+		ok      bool
+	}{
+		{"payload", []testTarEntry{
+			{"./", tar.TypeDir, "", ""},
+			{app, tar.TypeDir, "", ""},
+			{app + "video-mobile@.service.tmpl", tar.TypeReg, "[Unit]", ""},
+			{app + "metadata.json", tar.TypeReg, "{}", ""},
+			{app + "README.md", tar.TypeReg, "notes", ""},
+			{app + "NOTES.md", tar.TypeSymlink, "", "README.md"},
+		}, true},
+		{"implicit directories", []testTarEntry{{app + "deep/metadata.json", tar.TypeReg, "{}", ""}}, true},
+		{"absolute path", []testTarEntry{{"/etc/cron.d/evil", tar.TypeReg, "* * * * * root sh", ""}}, false},
+		{"parent path", []testTarEntry{{"../evil", tar.TypeReg, "x", ""}}, false},
+		{"nested parent path", []testTarEntry{{app + "../../evil", tar.TypeReg, "x", ""}}, false},
+		{"absolute symlink", []testTarEntry{{app + "etc", tar.TypeSymlink, "", "/etc"}}, false},
+		{"escaping symlink", []testTarEntry{{app + "up", tar.TypeSymlink, "", "../../.."}}, false},
+		{"forward symlink", []testTarEntry{
+			{app + "NOTES.md", tar.TypeSymlink, "", "README.md"},
+			{app + "README.md", tar.TypeReg, "notes", ""},
+		}, true},
+		{"symlink through symlink", []testTarEntry{
+			{"p/q/d", tar.TypeSymlink, "", "../.."},
+			{"p/q/leak", tar.TypeSymlink, "", "d/../../../secret.txt"},
+		}, false},
+		{"dangling symlink", []testTarEntry{{app + "missing", tar.TypeSymlink, "", "nothing"}}, false},
+		{"write through symlink", []testTarEntry{
+			{app + "here", tar.TypeSymlink, "", "."},
+			{app + "here/up", tar.TypeSymlink, "", ".."},
+		}, false},
+		{"overwrite symlink", []testTarEntry{
+			{app + "link", tar.TypeSymlink, "", "README.md"},
+			{app + "link", tar.TypeReg, "x", ""},
+		}, false},
+		{"hard link", []testTarEntry{{app + "passwd", tar.TypeLink, "", "/etc/passwd"}}, false},
+		{"device", []testTarEntry{{app + "null", tar.TypeChar, "", ""}}, false},
+		{"oversized file", []testTarEntry{{app + "big", tar.TypeReg, string(make([]byte, 101)), ""}}, false},
+		{"oversized archive", []testTarEntry{
+			{app + "a", tar.TypeReg, string(make([]byte, 100)), ""},
+			{app + "b", tar.TypeReg, string(make([]byte, 100)), ""},
+			{app + "c", tar.TypeReg, string(make([]byte, 100)), ""},
+		}, false},
+	}
+	for _, tc := range tests {
+		dir, err := ioutil.TempDir("", "extract")
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		workDir := filepath.Join(dir, "work")
+		os.Mkdir(workDir, 0755)
+		archive := writeTestTarGz(t, dir, tc.entries)
+		err = extractTarGz(archive, workDir, 100, 250)
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: expected success %t, received %v", tc.name, tc.ok, err)
+		}
+		// Nothing is ever written outside of the work directory.
+		files, _ := ioutil.ReadDir(dir)
+		if len(files) != 2 {
+			t.Errorf("%s: expected only the archive and the work directory, received %d files", tc.name,
+				len(files))
+		}
+		if tc.ok && tc.name == "payload" {
+			if b, err := ioutil.ReadFile(filepath.Join(workDir, app, "NOTES.md")); err != nil ||
+				string(b) != "notes" {
+				t.Errorf("%s: expected the symlinked notes, received %q (%v)", tc.name, b, err)
+			}
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestExtractExamplePayload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "extract")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	err = extractTarGz("../example.com-development-video-mobile-1.0.1-22.tar.gz", dir, maxExtractFileSize,
+		maxExtractSize)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	files, _ := ioutil.ReadDir(filepath.Join(dir, "example.com-development-video-mobile-1.0.1-22"))
+	if len(files) != 4 {
+		t.Errorf("Expected 4 files extracted from the example payload, received %d", len(files))
+	}
+}
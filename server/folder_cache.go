@@ -0,0 +1,198 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"sync"
+)
+
+// folderCache keeps the last listing of each artifactory folder, so conditional requests can be made and
+// the deploy versions of unchanged application folders are not scanned again.
+type folderCache struct {
+	mu          sync.Mutex
+	entries     map[string]*folderCacheEntry // The cached listings by folder path.
+	hits        int64                        // How many listings were unchanged.
+	misses      int64                        // How many listings were new or changed.
+	notModified int64                        // How many hits were answered 304 Not Modified.
+}
+
+// folderCacheEntry is the cached listing of a folder.
+type folderCacheEntry struct {
+	etag         string                      // The ETag header of the listing, if any.
+	lastModified string                      // The Last-Modified header of the listing, if any.
+	info         *ArtFolderInfo              // The listing.
+	versions     map[string][]*DeployVersion // The deploy versions scanned from the listing by version order.
+}
+
+// FolderCacheStatus contains runtime statistics of the folder cache.
+type FolderCacheStatus struct {
+	Folders     int   `json:"folders"`     // How many folder listings are cached.
+	Hits        int64 `json:"hits"`        // How many listings were unchanged.
+	Misses      int64 `json:"misses"`      // How many listings were new or changed.
+	NotModified int64 `json:"notModified"` // How many hits were answered 304 Not Modified.
+}
+
+// newFolderCache is a factory function that returns an empty folderCache.
+func newFolderCache() *folderCache {
+	return &folderCache{entries: make(map[string]*folderCacheEntry)}
+}
+
+// get returns the cached listing of a folder, or nil.
+func (c *folderCache) get(folder string) *folderCacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entries[folder]
+}
+
+// hit records an unchanged listing.
+func (c *folderCache) hit(notModified bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hits++
+	if notModified {
+		c.notModified++
+	}
+}
+
+// refresh records a listing with the same children as the cached one, and replaces the cached listing while
+// keeping the deploy versions scanned from it.
+func (c *folderCache) refresh(folder string, e *folderCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hits++
+	if old, ok := c.entries[folder]; ok {
+		e.versions = old.versions
+	}
+	c.entries[folder] = e
+}
+
+// miss records a new or changed listing and replaces the cached entry.
+func (c *folderCache) miss(folder string, e *folderCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.misses++
+	c.entries[folder] = e
+}
+
+// prune removes the cached listings of the folders in a parent folder that are no longer listed in it, so
+// the listings of deleted folders are not kept. present holds the paths of the folders still listed.
+func (c *folderCache) prune(parent string, present map[string]bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for folder := range c.entries {
+		if path.Dir(folder) == parent && !present[folder] {
+			delete(c.entries, folder)
+		}
+	}
+}
+
+// cachedVersions returns a copy of the deploy versions scanned from a cached listing with a version order.
+func (c *folderCache) cachedVersions(folder string, order string) ([]*DeployVersion, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[folder]
+	if !ok {
+		return nil, false
+	}
+	versions, ok := e.versions[order]
+	if !ok {
+		return nil, false
+	}
+	return append([]*DeployVersion{}, versions...), true
+}
+
+// storeVersions keeps the deploy versions scanned from the cached listing of a folder.
+func (c *folderCache) storeVersions(folder string, order string, versions []*DeployVersion) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[folder]; ok {
+		e.versions[order] = append([]*DeployVersion{}, versions...)
+	}
+}
+
+// status returns a snapshot of the cache statistics.
+func (c *folderCache) status() *FolderCacheStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return &FolderCacheStatus{
+		Folders:     len(c.entries),
+		Hits:        c.hits,
+		Misses:      c.misses,
+		NotModified: c.notModified,
+	}
+}
+
+// getArtFolderInfo retrieves the listing of an artifactory folder. A conditional request is made if the folder
+// is cached, and the listing is unchanged if artifactory answers 304 Not Modified or it has the same children.
+// The lastModified of a folder is not used, as artifactory does not always change it when children are added
+// or deleted.
+func (s *Server) getArtFolderInfo(folder string) (*ArtFolderInfo, bool, error) {
+	// evaluates as "http://art.com/foo/api" + "/storage" + "/" + "sub/directory" + "/"
+	req, err := s.newArtRequest(httpGet, fmt.Sprintf("%s%s/%s/", s.opts.ArtAPIEndpoint, artSourceRoute, folder), nil)
+	if err != nil {
+		return nil, false, err
+	}
+	cached := s.folders.get(folder)
+	if cached != nil {
+		if cached.etag != "" {
+			req.Header.Set("If-None-Match", cached.etag)
+		}
+		if cached.lastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false, err
+	}
+	if cached != nil && resp.StatusCode == http.StatusNotModified {
+		s.folders.hit(true)
+		return cached.info, true, nil
+	}
+	if err := artResponseError(resp, body); err != nil {
+		s.incrementArtErrorStats(err)
+		return nil, false, err
+	}
+	var fi ArtFolderInfo
+	if err := json.Unmarshal(body, &fi); err != nil {
+		return nil, false, err
+	}
+	e := &folderCacheEntry{
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		info:         &fi,
+		versions:     make(map[string][]*DeployVersion),
+	}
+	if cached != nil && sameChildren(cached.info, &fi) {
+		s.folders.refresh(folder, e)
+		return &fi, true, nil
+	}
+	s.folders.miss(folder, e)
+	return &fi, false, nil
+}
+
+// sameChildren returns true if two listings of a folder have the same files and folders.
+func sameChildren(a *ArtFolderInfo, b *ArtFolderInfo) bool {
+	if len(a.Children) != len(b.Children) {
+		return false
+	}
+	children := make(map[ArtFolderInfoChild]int)
+	for _, c := range a.Children {
+		children[*c]++
+	}
+	for _, c := range b.Children {
+		if children[*c] == 0 {
+			return false
+		}
+		children[*c]--
+	}
+	return true
+}
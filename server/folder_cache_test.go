@@ -0,0 +1,91 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestFolderCache(t *testing.T) {
+	for _, etags := range []bool{true, false} {
+		files := append([]*fakeArtFile{}, testDeployFiles...)
+		fake, ts := newFakeArtifactory(files)
+		fake.etags = etags
+		if !etags {
+			fake.modified = map[string]string{
+				"":             "2015-09-03T10:00:00.000Z",
+				"video-mobile": "2015-09-02T10:00:00.000Z",
+				"search-api":   "2015-09-03T10:00:00.000Z",
+			}
+		}
+		s := newTestServer(ts.URL, DiscoveryFolders)
+		target := s.monitors[0].target
+		storageCalls := func() int { return fake.requestCount(httpGet, "/api"+artSourceRoute) }
+
+		// The first poll lists every folder and reads the created dates of search-api.
+		if _, err := s.getDeployVersions(target, ""); err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		if st := s.folders.status(); storageCalls() != 5 || st.Folders != 3 || st.Misses != 3 || st.Hits != 0 {
+			t.Errorf("ETags %t: unexpected first poll, %d storage calls and %+v", etags, storageCalls(), st)
+		}
+
+		// Unchanged folders are not scanned again.
+		deploys, err := s.getDeployVersions(target, "")
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		st := s.folders.status()
+		if storageCalls() != 8 || st.Misses != 3 || st.Hits != 3 {
+			t.Errorf("ETags %t: unexpected second poll, %d storage calls and %+v", etags, storageCalls(), st)
+		}
+		if (etags && st.NotModified != 3) || (!etags && st.NotModified != 0) {
+			t.Errorf("ETags %t: unexpected not modified count %d.", etags, st.NotModified)
+		}
+		if len(deploys["video-mobile"]) != 2 || len(deploys["search-api"]) != 2 ||
+			latestDeployVersion(deploys["search-api"],
+				versionOrdering(VersionOrderSemantic, deploys["search-api"])).Tag != "nightly" {
+			t.Errorf("ETags %t: unexpected cached deploy versions %v", etags, deploys)
+		}
+
+		// Changed folders are scanned again, even when artifactory keeps their lastModified.
+		fake.mu.Lock()
+		fake.files = append(fake.files,
+			&fakeArtFile{"video-mobile", "1.0.11-1.deploy", "2015-09-04T10:00:00.000Z", "jenkins"},
+			&fakeArtFile{"search-web", "2.0.0-1.deploy", "2015-09-04T10:00:00.000Z", "jenkins"})
+		fake.mu.Unlock()
+		deploys, err = s.getDeployVersions(target, "")
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		if st := s.folders.status(); st.Misses != 6 || st.Hits != 4 {
+			t.Errorf("ETags %t: unexpected third poll %+v", etags, st)
+		}
+		if len(deploys["video-mobile"]) != 3 || len(deploys["search-web"]) != 1 {
+			t.Errorf("ETags %t: expected 3 versions of video-mobile and 1 of search-web, received %v.", etags,
+				deploys)
+		}
+
+		// The listings of deleted folders are dropped.
+		fake.mu.Lock()
+		kept := make([]*fakeArtFile, 0, len(fake.files))
+		for _, f := range fake.files {
+			if f.path != "search-api" {
+				kept = append(kept, f)
+			}
+		}
+		fake.files = kept
+		if !etags {
+			delete(fake.modified, "search-api")
+		}
+		fake.mu.Unlock()
+		deploys, err = s.getDeployVersions(target, "")
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		if st := s.folders.status(); st.Folders != 3 || len(deploys) != 2 ||
+			s.folders.get(testDeployRepo+"/search-api") != nil {
+			t.Errorf("ETags %t: expected the search-api listing to be dropped, received %+v and %v", etags, st,
+				deploys)
+		}
+		ts.Close()
+	}
+}
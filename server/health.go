@@ -0,0 +1,226 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HealthCheck is the result of checking one dependency of the server.
+type HealthCheck struct {
+	Name    string  `json:"name"`              // The dependency checked ex: database, artifactory, coreos-deploy, poll.
+	Detail  string  `json:"detail,omitempty"`  // The URL or target checked, if any.
+	Healthy bool    `json:"healthy"`           // True if the dependency is usable.
+	Message string  `json:"message,omitempty"` // Why the dependency is not usable, or more about its state.
+	Seconds float64 `json:"seconds,omitempty"` // How long the check took.
+}
+
+// ReadinessStatus is the response of a readiness request.
+type ReadinessStatus struct {
+	Ready  bool           `json:"ready"`  // True if every dependency is healthy.
+	Checks []*HealthCheck `json:"checks"` // The result of each dependency check.
+}
+
+// readinessCache keeps the last readiness result for readyCacheTTL, so frequent readiness requests do not
+// flood the dependencies.
+type readinessCache struct {
+	mu        sync.Mutex
+	status    *ReadinessStatus // The last result.
+	checkedAt time.Time        // When the last result was checked.
+}
+
+// readinessHandler handles a client "is the server able to do its job?" request. Each dependency is checked and
+// 503 Service Unavailable is returned if any is unhealthy. Only the health of each dependency is returned,
+// unless the request is authorized for the URLs checked and why they are not healthy.
+func (s *Server) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	if s.invalidMethod(w, r, httpGet) {
+		return
+	}
+	detailed := r.Header.Get("Authorization") != ""
+	if detailed && s.invalidAuth(w, r) {
+		return
+	}
+	st := s.cachedReadiness()
+	if !detailed {
+		st = st.summary()
+	}
+	if !st.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	b, _ := json.Marshal(st)
+	w.Write(b)
+}
+
+// cachedReadiness returns the last readiness result if it is recent, otherwise it checks the dependencies
+// again. Concurrent requests wait for the same checks.
+func (s *Server) cachedReadiness() *ReadinessStatus {
+	c := s.ready
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.status == nil || time.Since(c.checkedAt) > readyCacheTTL {
+		c.status = s.readiness()
+		c.checkedAt = time.Now()
+	}
+	return c.status
+}
+
+// summary returns the readiness with only the name and health of each check.
+func (st *ReadinessStatus) summary() *ReadinessStatus {
+	result := &ReadinessStatus{Ready: st.Ready, Checks: make([]*HealthCheck, 0, len(st.Checks))}
+	for _, hc := range st.Checks {
+		result.Checks = append(result.Checks, &HealthCheck{Name: hc.Name, Healthy: hc.Healthy})
+	}
+	return result
+}
+
+// readiness runs the dependency checks concurrently and returns their results.
+func (s *Server) readiness() *ReadinessStatus {
+	checks := []func() *HealthCheck{s.checkDatabase, s.checkArtifactory}
+	for _, url := range s.deployURLs() {
+		url := url
+		checks = append(checks, func() *HealthCheck { return s.checkDeployService(url) })
+	}
+	s.mu.RLock()
+	for _, tm := range s.monitors {
+		tm := tm
+		checks = append(checks, func() *HealthCheck { return s.checkPoll(tm) })
+	}
+	s.mu.RUnlock()
+
+	st := &ReadinessStatus{Ready: true, Checks: make([]*HealthCheck, len(checks))}
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check func() *HealthCheck) {
+			defer wg.Done()
+			start := time.Now()
+			hc := check()
+			hc.Seconds = time.Since(start).Seconds()
+			st.Checks[i] = hc
+		}(i, check)
+	}
+	wg.Wait()
+	for _, hc := range st.Checks {
+		st.Ready = st.Ready && hc.Healthy
+	}
+	return st
+}
+
+// checkDatabase pings the database.
+func (s *Server) checkDatabase() *HealthCheck {
+	hc := &HealthCheck{Name: "database"}
+	if s.db == nil {
+		hc.Message = "Not connected"
+		return hc
+	}
+	if err := s.db.Ping(); err != nil {
+		hc.Message = err.Error()
+		return hc
+	}
+	hc.Healthy = true
+	return hc
+}
+
+// checkArtifactory pings the artifactory API with the credentials of the server.
+func (s *Server) checkArtifactory() *HealthCheck {
+	// evaluates as "http://art.com/foo/api" + "/system/ping"
+	url := fmt.Sprintf("%s%s", s.opts.ArtAPIEndpoint, artPingRoute)
+	hc := &HealthCheck{Name: "artifactory", Detail: url}
+	req, err := s.newArtRequest(httpGet, url, nil)
+	if err != nil {
+		hc.Message = err.Error()
+		return hc
+	}
+	if err := s.readinessRequest(req); err != nil {
+		hc.Message = err.Error()
+		return hc
+	}
+	hc.Healthy = true
+	return hc
+}
+
+// checkDeployService checks a coreos-deploy service is reachable through its health route.
+func (s *Server) checkDeployService(deployURL string) *HealthCheck {
+	// evaluates as "http://coreos.example.com:80" + "/v1.0/health"
+	url := fmt.Sprintf("%s%s", deployURL, deployHealthRoute)
+	hc := &HealthCheck{Name: "coreos-deploy", Detail: url}
+	req, err := http.NewRequest(httpGet, url, nil)
+	if err != nil {
+		hc.Message = err.Error()
+		return hc
+	}
+	if err := s.readinessRequest(req); err != nil {
+		hc.Message = err.Error()
+		return hc
+	}
+	hc.Healthy = true
+	return hc
+}
+
+// checkPoll checks the last successful poll of a target is within the number of polling intervals allowed.
+// Before its first poll, the time since the server started is used.
+func (s *Server) checkPoll(tm *targetMonitor) *HealthCheck {
+	st := tm.status()
+	hc := &HealthCheck{Name: "poll", Detail: tm.target.key()}
+	last := st.LastSuccess
+	if last.IsZero() {
+		s.mu.RLock()
+		last = s.stats.Start
+		s.mu.RUnlock()
+	}
+	limit := time.Duration(s.opts.ReadyPollIntervals*s.opts.ArtPollingInterval) * time.Second
+	if since := time.Since(last); since > limit {
+		hc.Message = fmt.Sprintf("No successful poll for %s", since-since%time.Second)
+		if st.LastError != "" {
+			hc.Message = fmt.Sprintf("%s: %s", hc.Message, st.LastError)
+		}
+		return hc
+	}
+	if !st.LastSuccess.IsZero() {
+		hc.Message = fmt.Sprintf("Last successful poll at %s", st.LastSuccess.Format(time.RFC3339))
+	}
+	hc.Healthy = true
+	return hc
+}
+
+// deployURLs returns the unique coreos-deploy url endpoints of the targets, sorted.
+func (s *Server) deployURLs() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	seen := make(map[string]bool)
+	for _, tm := range s.monitors {
+		seen[tm.target.DeployURL] = true
+		for _, url := range tm.target.DeployClusters {
+			seen[url] = true
+		}
+	}
+	urls := make([]string, 0, len(seen))
+	for url := range seen {
+		if url != "" {
+			urls = append(urls, url)
+		}
+	}
+	sort.Strings(urls)
+	return urls
+}
+
+// readinessRequest sends a readiness check request without retries and returns an error if the response
+// is not 2xx.
+func (s *Server) readinessRequest(req *http.Request) error {
+	cl := &http.Client{Transport: s.client.transport, Timeout: readyCheckTimeout}
+	resp, err := cl.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body) // Let the connection be reused.
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Status %d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadinessHandler(t *testing.T) {
+	_, art := newFakeArtifactory(testDeployFiles)
+	defer art.Close()
+	var mu sync.Mutex
+	deployChecks := 0
+	deploy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		deployChecks++
+		mu.Unlock()
+		if r.URL.Path != deployHealthRoute {
+			http.NotFound(w, r)
+		}
+	}))
+	defer deploy.Close()
+
+	s := newTestServer(art.URL, DiscoveryAQL)
+	s.opts.ArtPollingInterval = 60
+	s.opts.ReadyPollIntervals = 3
+	d, raw := newTestDB(t, "readiness")
+	defer raw.Close()
+	s.db = d
+	tm := s.monitors[0]
+	tm.target.DeployURL = deploy.URL
+	tm.target.DeployClusters = map[string]string{"east": deploy.URL, "west": deploy.URL + "/west"}
+
+	// The readiness of authorized requests, checked again.
+	readiness := func() (int, map[string]*HealthCheck) {
+		s.ready = &readinessCache{}
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(httpGet, httpRouteV1HealthReady, nil)
+		req.Header.Set("Authorization", "Bearer "+testAuthToken)
+		s.readinessHandler(w, req)
+		st := &ReadinessStatus{}
+		if err := json.Unmarshal(w.Body.Bytes(), st); err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		checks := make(map[string]*HealthCheck)
+		for _, hc := range st.Checks {
+			checks[hc.Name+" "+hc.Detail] = hc
+		}
+		return w.Code, checks
+	}
+
+	// The west cluster does not answer its health route.
+	code, checks := readiness()
+	if code != http.StatusServiceUnavailable || len(checks) != 5 {
+		t.Errorf("Expected 5 checks and not ready, received %d: %v", code, checks)
+	}
+	for name, healthy := range map[string]bool{
+		"database ": true,
+		"artifactory " + art.URL + "/api" + artPingRoute:            true,
+		"coreos-deploy " + deploy.URL + deployHealthRoute:           true,
+		"coreos-deploy " + deploy.URL + "/west" + deployHealthRoute: false,
+		"poll " + tm.target.key():                                   true,
+	} {
+		if hc, ok := checks[name]; !ok || hc.Healthy != healthy {
+			t.Errorf("Expected check %q healthy %t, received %+v", name, healthy, hc)
+		}
+	}
+
+	delete(tm.target.DeployClusters, "west")
+	if code, checks := readiness(); code != http.StatusOK || len(checks) != 4 {
+		t.Errorf("Expected ready with 4 checks, received %d: %v", code, checks)
+	}
+
+	// A target that has not polled successfully for too long is not ready.
+	tm.mu.Lock()
+	tm.lastSuccess = time.Now().Add(-4 * time.Minute)
+	tm.lastError = "Artifactory API error 401 Unauthorized"
+	tm.mu.Unlock()
+	if code, checks := readiness(); code != http.StatusServiceUnavailable ||
+		checks["poll "+tm.target.key()].Healthy {
+		t.Errorf("Expected a stale poll to be not ready, received %d: %v", code, checks)
+	}
+	tm.polled(nil, 0)
+
+	// An invalid token is refused.
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(httpGet, httpRouteV1HealthReady, nil)
+	req.Header.Set("Authorization", "Bearer invalid")
+	s.readinessHandler(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected an invalid token to be unauthorized, received %d.", w.Code)
+	}
+
+	// Without a token only the health of each dependency is returned, and the checks are reused for a while.
+	s.ready = &readinessCache{}
+	mu.Lock()
+	deployChecks = 0
+	mu.Unlock()
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(httpGet, httpRouteV1HealthReady, nil)
+		s.readinessHandler(w, req)
+		st := &ReadinessStatus{}
+		json.Unmarshal(w.Body.Bytes(), st)
+		if w.Code != http.StatusOK || !st.Ready || len(st.Checks) != 4 {
+			t.Errorf("Expected ready with 4 checks, received %d: %s", w.Code, w.Body.String())
+		}
+		if strings.Contains(w.Body.String(), deploy.URL) || strings.Contains(w.Body.String(), "detail") ||
+			strings.Contains(w.Body.String(), "message") {
+			t.Errorf("Expected no details without a token, received %s", w.Body.String())
+		}
+	}
+	mu.Lock()
+	if deployChecks != 1 {
+		t.Errorf("Expected the coreos-deploy service checked once, received %d.", deployChecks)
+	}
+	mu.Unlock()
+
+	// A lost database is not ready.
+	d.Close()
+	s.ready = &readinessCache{}
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(httpGet, httpRouteV1HealthReady, nil)
+	s.readinessHandler(w, req)
+	if !strings.Contains(w.Body.String(), `{"name":"database","healthy":false}`) {
+		t.Errorf("Expected a closed database to be not ready, received %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"io"
+	"io/ioutil"
+	mr "math/rand"
+	"net/http"
+	"time"
+
+	"github.com/composer22/coreos-artifactory-monitor/logger"
+)
+
+// httpClient is the shared client for outbound HTTP requests. Every attempt is given a timeout, and
+// idempotent requests that fail with a network error or a retryable status are retried with a bounded
+// exponential backoff and jitter.
+type httpClient struct {
+	transport  http.RoundTripper // The shared connection pool.
+	timeout    time.Duration     // The default timeout of each attempt.
+	retries    int               // The maximum number of retries of a request.
+	backoff    time.Duration     // The backoff before the first retry.
+	backoffMax time.Duration     // The maximum backoff between retries.
+	budget     time.Duration     // The maximum time spent on all the attempts of a request.
+	log        *logger.Logger    // Log instance for recording retries.
+}
+
+// newHTTPClient is a factory function that returns an httpClient configured from the options.
+func newHTTPClient(o *Options, l *logger.Logger) *httpClient {
+	return &httpClient{
+		transport:  http.DefaultTransport,
+		timeout:    time.Duration(o.HTTPTimeout) * time.Second,
+		retries:    o.HTTPRetries,
+		backoff:    time.Duration(o.HTTPBackoff) * time.Millisecond,
+		backoffMax: time.Duration(o.HTTPBackoffMax) * time.Millisecond,
+		budget:     time.Duration(o.HTTPRetryBudget) * time.Second,
+		log:        l,
+	}
+}
+
+// Do sends a request using the default timeout for each attempt.
+func (c *httpClient) Do(req *http.Request) (*http.Response, error) {
+	return c.DoTimeout(req, c.timeout)
+}
+
+// DoTimeout sends a request using the given timeout for each attempt. The timeout includes reading
+// the response body. A timeout <= 0 means no timeout.
+func (c *httpClient) DoTimeout(req *http.Request, timeout time.Duration) (*http.Response, error) {
+	cl := c.attemptClient(timeout)
+	start := time.Now()
+	for attempt := 0; ; attempt++ {
+		resp, err := cl.Do(req)
+		if attempt >= c.retries || !idempotentRequest(req) || !retryableResponse(resp, err) {
+			return resp, err
+		}
+		pause := c.backoffPause(attempt)
+		if c.budget > 0 && time.Since(start)+pause > c.budget {
+			return resp, err
+		}
+		if err != nil {
+			c.log.Warningf("Retrying %s %s in %s: %s", req.Method, req.URL, pause, err.Error())
+		} else {
+			c.log.Warningf("Retrying %s %s in %s: status %d", req.Method, req.URL, pause, resp.StatusCode)
+			io.Copy(ioutil.Discard, resp.Body) // Let the connection be reused.
+			resp.Body.Close()
+		}
+		time.Sleep(pause)
+	}
+}
+
+// DoOnce sends a request once using the given timeout, for callers that retry themselves. A timeout <= 0
+// means no timeout.
+func (c *httpClient) DoOnce(req *http.Request, timeout time.Duration) (*http.Response, error) {
+	return c.attemptClient(timeout).Do(req)
+}
+
+// attemptClient returns a client of the shared connection pool with the timeout of an attempt.
+func (c *httpClient) attemptClient(timeout time.Duration) *http.Client {
+	cl := &http.Client{Transport: c.transport}
+	if timeout > 0 {
+		cl.Timeout = timeout
+	}
+	return cl
+}
+
+// backoffPause returns the pause before a retry: the backoff doubled for each attempt, capped at the
+// maximum, with jitter of up to half of the pause.
+func (c *httpClient) backoffPause(attempt int) time.Duration {
+	pause := c.backoff
+	for i := 0; i < attempt && (c.backoffMax <= 0 || pause < c.backoffMax); i++ {
+		pause *= 2
+	}
+	if c.backoffMax > 0 && pause > c.backoffMax {
+		pause = c.backoffMax
+	}
+	if half := int64(pause / 2); half > 0 {
+		pause = time.Duration(half + mr.Int63n(half+1))
+	}
+	return pause
+}
+
+// idempotentRequest returns true if a request can be safely sent again.
+func idempotentRequest(req *http.Request) bool {
+	return (req.Method == httpGet || req.Method == httpHead) && req.Body == nil
+}
+
+// retryableResponse returns true if the result of an attempt is a network error or a status that might
+// succeed later.
+func retryableResponse(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+}
+
+// setDefaultTransportTimeouts bounds the time the default transport waits for a response. Clients we do
+// not construct, such as the coreos-deploy client library, use the default transport.
+func setDefaultTransportTimeouts(o *Options) {
+	if t, ok := http.DefaultTransport.(*http.Transport); ok && o.HTTPTimeout > 0 {
+		t.ResponseHeaderTimeout = time.Duration(o.HTTPTimeout) * time.Second
+	}
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/composer22/coreos-artifactory-monitor/logger"
+)
+
+// newTestHTTPClient returns a client with short pauses for testing retries.
+func newTestHTTPClient(retries int) *httpClient {
+	return newHTTPClient(&Options{
+		HTTPTimeout:     1,
+		HTTPRetries:     retries,
+		HTTPBackoff:     1,
+		HTTPBackoffMax:  5,
+		HTTPRetryBudget: 5,
+	}, logger.New(logger.Error, false))
+}
+
+func TestHTTPClientRetries(t *testing.T) {
+	tests := []struct {
+		method   string
+		failures int32 // How many attempts fail before one succeeds.
+		status   int   // The status of the failures.
+		retries  int
+		attempts int32
+		expected int
+	}{
+		{httpGet, 2, http.StatusServiceUnavailable, 3, 3, http.StatusOK},
+		{httpGet, 5, http.StatusBadGateway, 3, 4, http.StatusBadGateway},
+		{httpGet, 1, http.StatusTooManyRequests, 3, 2, http.StatusOK},
+		{httpGet, 1, http.StatusNotFound, 3, 1, http.StatusNotFound},
+		{httpGet, 2, http.StatusInternalServerError, 0, 1, http.StatusInternalServerError},
+		{httpPost, 2, http.StatusServiceUnavailable, 3, 1, http.StatusServiceUnavailable},
+	}
+	for i, tc := range tests {
+		var attempts int32
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&attempts, 1) <= tc.failures {
+				w.WriteHeader(tc.status)
+			}
+		}))
+		var req *http.Request
+		if tc.method == httpPost {
+			req, _ = http.NewRequest(tc.method, ts.URL, strings.NewReader("query"))
+		} else {
+			req, _ = http.NewRequest(tc.method, ts.URL, nil)
+		}
+		resp, err := newTestHTTPClient(tc.retries).Do(req)
+		ts.Close()
+		if err != nil {
+			t.Errorf("Test %d: unexpected error: %s", i, err.Error())
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.expected || attempts != tc.attempts {
+			t.Errorf("Test %d: expected status %d after %d attempts, received %d after %d.", i, tc.expected,
+				tc.attempts, resp.StatusCode, attempts)
+		}
+	}
+}
+
+func TestHTTPClientTimeout(t *testing.T) {
+	release := make(chan bool)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	req, _ := http.NewRequest(httpGet, ts.URL, nil)
+	start := time.Now()
+	_, err := newTestHTTPClient(0).DoTimeout(req, 50*time.Millisecond)
+	if err == nil {
+		t.Errorf("A hung request should time out.")
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("The request timeout was not applied.")
+	}
+}
+
+func TestHTTPClientBackoffPause(t *testing.T) {
+	c := &httpClient{backoff: 100 * time.Millisecond, backoffMax: time.Second}
+	for attempt := 0; attempt < 10; attempt++ {
+		max := 100 * time.Millisecond << uint(attempt)
+		if max > time.Second {
+			max = time.Second
+		}
+		if pause := c.backoffPause(attempt); pause < max/2 || pause > max {
+			t.Errorf("Attempt %d: pause %s is outside of %s to %s.", attempt, pause, max/2, max)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/composer22/coreos-artifactory-monitor/db"
+	"gopkg.in/yaml.v2"
+)
+
+// DeployManifest is the optional content of a .deploy request file, in JSON or YAML. RequestedBy, Reason,
+// Strategy and TicketID are only recorded with the deploy, as the coreos-deploy client has no fields for them.
+type DeployManifest struct {
+	RequestedBy  string `json:"requestedBy,omitempty" yaml:"requestedBy"`   // Who requested the deploy.
+	Reason       string `json:"reason,omitempty" yaml:"reason"`             // Why the deploy was requested.
+	NumInstances int    `json:"numInstances,omitempty" yaml:"numInstances"` // Overrides numInstances of the metadata.
+	Cluster      string `json:"cluster,omitempty" yaml:"cluster"`           // The target cluster, see --deploy_clusters.
+	Strategy     string `json:"strategy,omitempty" yaml:"strategy"`         // The rollout, not supported by coreos-deploy.
+	TicketID     string `json:"ticketID,omitempty" yaml:"ticketID"`         // The change ticket of the deploy.
+}
+
+// parseDeployManifest parses the content of a .deploy file. A file starting with "{" is parsed as JSON,
+// anything else as YAML. Unknown fields are an error. An empty file returns a nil manifest. A reason or a
+// manifest too long to be recorded is an error.
+func parseDeployManifest(b []byte) (*DeployManifest, error) {
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 {
+		return nil, nil
+	}
+	m := &DeployManifest{}
+	if b[0] == '{' {
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(m); err != nil {
+			return nil, fmt.Errorf("Invalid JSON manifest: %s", err.Error())
+		}
+	} else if err := yaml.UnmarshalStrict(b, m); err != nil {
+		return nil, fmt.Errorf("Invalid YAML manifest: %s", err.Error())
+	}
+	if m.NumInstances < 0 {
+		return nil, fmt.Errorf("Invalid manifest numInstances: %d", m.NumInstances)
+	}
+	if len(m.Reason) > maxManifestReason {
+		return nil, fmt.Errorf("Manifest reason of %d bytes exceeds the maximum of %d bytes", len(m.Reason),
+			maxManifestReason)
+	}
+	if n := len(m.String()); n > db.MaxManifestLength {
+		return nil, fmt.Errorf("Manifest of %d bytes as JSON exceeds the maximum of %d bytes", n,
+			db.MaxManifestLength)
+	}
+	return m, nil
+}
+
+// String is an implentation of the Stringer interface so the structure is returned as a string
+// to fmt.Print() etc.
+func (m *DeployManifest) String() string {
+	b, _ := json.Marshal(m)
+	return string(b)
+}
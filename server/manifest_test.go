@@ -0,0 +1,71 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/composer22/coreos-artifactory-monitor/db"
+)
+
+func TestParseDeployManifest(t *testing.T) {
+	expected := DeployManifest{
+		RequestedBy:  "jdoe",
+		Reason:       "Fix playback.",
+		NumInstances: 3,
+		Cluster:      "east",
+		Strategy:     "rolling",
+		TicketID:     "CHG-1234",
+	}
+	tests := []struct {
+		content string
+		valid   bool
+		empty   bool
+	}{
+		{"", true, true},
+		{" \n", true, true},
+		{`{"requestedBy":"jdoe","reason":"Fix playback.","numInstances":3,"cluster":"east",` +
+			`"strategy":"rolling","ticketID":"CHG-1234"}`, true, false},
+		{"requestedBy: jdoe\nreason: Fix playback.\nnumInstances: 3\ncluster: east\nstrategy: rolling\n" +
+			"ticketID: CHG-1234\n", true, false},
+		{`{"requestedBy":"` + strings.Repeat("x", 3000) + `"}`, false, false},
+		{`{"requestedBy":"jdoe","requestor":"jdoe"}`, false, false},
+		{"requestedBy: jdoe\nrequestor: jdoe\n", false, false},
+		{`{"numInstances":"three"}`, false, false},
+		{"numInstances: -1\n", false, false},
+		{"- not a manifest\n", false, false},
+	}
+	for _, tc := range tests {
+		m, err := parseDeployManifest([]byte(tc.content))
+		switch {
+		case !tc.valid:
+			if err == nil {
+				t.Errorf("Expected an error parsing %q.", tc.content)
+			}
+		case err != nil:
+			t.Errorf("Unexpected error parsing %q: %s", tc.content, err.Error())
+		case tc.empty:
+			if m != nil {
+				t.Errorf("Expected no manifest parsing %q, received %s.", tc.content, m)
+			}
+		case m == nil || *m != expected:
+			t.Errorf("Manifest parsing %q: expected %s, received %s.", tc.content, &expected, m)
+		}
+	}
+}
+
+func TestParseDeployManifestReason(t *testing.T) {
+	reason := strings.Repeat("é", maxManifestReason/2)
+	m, err := parseDeployManifest([]byte(`{"reason":"` + reason + `"}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	var decoded DeployManifest
+	if err := json.Unmarshal([]byte(m.String()), &decoded); err != nil || len(m.String()) > db.MaxManifestLength {
+		t.Errorf("Expected the manifest recorded as valid JSON within the column: %v", err)
+	}
+	if _, err := parseDeployManifest([]byte(`{"reason":"` + reason + `."}`)); err == nil ||
+		!strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("Expected a reason over %d bytes to be an error, received %v.", maxManifestReason, err)
+	}
+}
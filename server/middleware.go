@@ -13,7 +13,7 @@ type Middleware struct {
 // by the server.
 func (m *Middleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Don't log health checks
-	if r.URL.Path != httpRouteV1Health {
+	if r.URL.Path != httpRouteV1Health && r.URL.Path != httpRouteV1HealthLive && r.URL.Path != httpRouteV1HealthReady {
 		m.serv.LogRequest(r)
 	}
 	m.serv.incrementStats(r)
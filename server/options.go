@@ -3,33 +3,135 @@ package server
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"sort"
+	"strings"
 )
 
 // Options represents parameters that are passed to the application to be used in constructing
 // the server.
 type Options struct {
-	Name               string `json:"name"`               // The name of the server.
-	HostName           string `json:"hostName"`           // The hostname of the server.
-	Domain             string `json:"domain"`             // The domain of the server.
-	Environment        string `json:"environment"`        // The environment of the server (dev, stage, prod, etc).
-	DeployURL          string `json:"deployURL"`          // The coreos-deploy url endpoint.
-	DeployToken        string `json:"-"`                  // The coreos-deploy token for security access.
-	ArtAPIEndpoint     string `json:"artAPIEndpoint"`     // The artifactory API endpoint.
-	ArtUserID          string `json:"-"`                  // The artifactory user id.
-	ArtPassword        string `json:"-"`                  // The artifactory password.
-	ArtPollingInterval int    `json:"artPollingInterval"` // The artifactory polling interval in seconds.
-	ArtDeployRepo      string `json:"artDeployRepo"`      // The artifactory repo of the deploy request files.
-	ArtPayloadRepo     string `json:"artPayloadRepo"`     // The artifactory repo of the deployment payloads.
-	Port               int    `json:"port"`               // The default port of the server.
-	ProfPort           int    `json:"profPort"`           // The profiler port of the server.
-	DSN                string `json:"-"`                  // The DSN login string to the database.
-	MaxProcs           int    `json:"maxProcs"`           // The maximum number of processor cores available.
-	Debug              bool   `json:"debugEnabled"`       // Is debugging enabled in the application or server.
+	Name               string            `json:"name"`               // The name of the server.
+	HostName           string            `json:"hostName"`           // The hostname of the server.
+	Domain             string            `json:"domain"`             // The domain of the server.
+	Environment        string            `json:"environment"`        // The environment of the server (dev, stage, prod, etc).
+	DeployURL          string            `json:"deployURL"`          // The coreos-deploy url endpoint.
+	DeployToken        string            `json:"-"`                  // The coreos-deploy token for security access.
+	DeployClusters     map[string]string `json:"deployClusters"`     // The coreos-deploy url endpoints by cluster.
+	ArtAPIEndpoint     string            `json:"artAPIEndpoint"`     // The artifactory API endpoint.
+	ArtAuthMode        string            `json:"artAuthMode"`        // How artifactory requests are authenticated.
+	ArtUserID          string            `json:"-"`                  // The artifactory user id.
+	ArtPassword        string            `json:"-"`                  // The artifactory password.
+	ArtAPIKey          string            `json:"-"`                  // The artifactory API key.
+	ArtAccessToken     string            `json:"-"`                  // The artifactory bearer access token.
+	ArtRefreshToken    string            `json:"-"`                  // The artifactory refresh token.
+	ArtPollingInterval int               `json:"artPollingInterval"` // The artifactory polling interval in seconds.
+	ArtDeployRepo      string            `json:"artDeployRepo"`      // The artifactory repo of the deploy request files.
+	ArtPayloadRepo     string            `json:"artPayloadRepo"`     // The artifactory repo of the deployment payloads.
+	WebhookSecret      string            `json:"-"`                  // The shared secret for artifactory webhooks.
+	ArtDiscovery       string            `json:"artDiscovery"`       // How deploy request files are found (aql, folders).
+	MaxDeploys         int               `json:"maxDeploys"`         // Maximum deploy jobs running at once.
+	HTTPTimeout        int               `json:"httpTimeout"`        // Timeout in seconds of outbound API requests.
+	DownloadTimeout    int               `json:"downloadTimeout"`    // Timeout in seconds of payload downloads.
+	MaxPayloadSize     int               `json:"maxPayloadSize"`     // Maximum size in MB of payload downloads.
+	PayloadCacheDir    string            `json:"payloadCacheDir"`    // The directory of the verified payloads cached.
+	PayloadCacheSize   int               `json:"payloadCacheSize"`   // Maximum size in MB of the payload cache.
+	HTTPRetries        int               `json:"httpRetries"`        // Maximum retries of idempotent requests.
+	HTTPBackoff        int               `json:"httpBackoff"`        // Backoff in milliseconds before the first retry.
+	HTTPBackoffMax     int               `json:"httpBackoffMax"`     // Maximum backoff in milliseconds between retries.
+	HTTPRetryBudget    int               `json:"httpRetryBudget"`    // Maximum seconds spent on attempts of a request.
+	ReadyPollIntervals int               `json:"readyPollIntervals"` // Polling intervals allowed since the last good poll.
+	TLSCAFile          string            `json:"tlsCAFile"`          // The PEM CA bundle trusted by outbound connections.
+	TLSCertFile        string            `json:"tlsCertFile"`        // The PEM client certificate of outbound connections.
+	TLSKeyFile         string            `json:"-"`                  // The PEM key of the client certificate.
+	TLSMinVersion      string            `json:"tlsMinVersion"`      // The minimum TLS version of outbound connections.
+	VersionOrder       string            `json:"versionOrder"`       // How deploy versions are ordered by default.
+	AppVersionOrders   map[string]string `json:"appVersionOrders"`   // Version ordering overrides by application.
+	ScheduleFile       string            `json:"scheduleFile"`       // The JSON file of deploy windows and freezes.
+	PropertiesFile     string            `json:"propertiesFile"`     // The JSON file of the payload properties required.
+	SignaturesFile     string            `json:"signaturesFile"`     // The JSON file of the trusted payload signing keys.
+	AppIncludes        []string          `json:"appIncludes"`        // Patterns of the applications managed.
+	AppExcludes        []string          `json:"appExcludes"`        // Patterns of the applications not managed.
+	FilterFile         string            `json:"filterFile"`         // The JSON file of more application patterns.
+	TargetsFile        string            `json:"targetsFile"`        // The JSON file of the targets managed.
+	Port               int               `json:"port"`               // The default port of the server.
+	ProfPort           int               `json:"profPort"`           // The profiler port of the server.
+	DSN                string            `json:"-"`                  // The DSN login string to the database.
+	MaxProcs           int               `json:"maxProcs"`           // The maximum number of processor cores available.
+	Debug              bool              `json:"debugEnabled"`       // Is debugging enabled in the application or server.
 }
 
 // Validate options
 // TBD: Fix these validations for the current keyset
 func (o *Options) Validate() error {
+	// The targets of a targets file are validated when it is loaded.
+	if o.TargetsFile == "" {
+		if err := o.validateTarget(); err != nil {
+			return err
+		}
+	}
+	if o.ArtAPIEndpoint == "" {
+		return errors.New("Artifactory API endpoint is mandatory.")
+	}
+	switch o.ArtAuthMode {
+	case ArtAuthBasic:
+		if o.ArtUserID == "" {
+			return errors.New("Artifactory API user id is mandatory.")
+		}
+	case ArtAuthAPIKey:
+		if o.ArtAPIKey == "" {
+			return errors.New("Artifactory API key is mandatory for apikey authentication.")
+		}
+	case ArtAuthToken:
+		if o.ArtAccessToken == "" {
+			return errors.New("Artifactory access token is mandatory for token authentication.")
+		}
+	default:
+		return fmt.Errorf("Artifactory authentication mode %s is invalid.", o.ArtAuthMode)
+	}
+	if o.DSN == "" {
+		return errors.New("DNS database settings are mandatory.")
+	}
+	if o.ArtDiscovery != DiscoveryAQL && o.ArtDiscovery != DiscoveryFolders {
+		return fmt.Errorf("Artifactory discovery mode %s is invalid.", o.ArtDiscovery)
+	}
+	if o.HTTPTimeout <= 0 {
+		return errors.New("HTTP timeout must be greater than zero.")
+	}
+	if o.HTTPRetries < 0 || o.HTTPBackoff < 0 {
+		return errors.New("HTTP retries and backoff cannot be negative.")
+	}
+	if o.MaxPayloadSize < 0 {
+		return errors.New("Maximum payload size cannot be negative.")
+	}
+	if o.PayloadCacheSize < 0 {
+		return errors.New("Payload cache size cannot be negative.")
+	}
+	if o.PayloadCacheSize > 0 && o.PayloadCacheDir == "" {
+		return errors.New("Payload cache directory is mandatory when the cache is enabled.")
+	}
+	if err := o.validateTLS(); err != nil {
+		return err
+	}
+	if o.ReadyPollIntervals <= 0 {
+		return errors.New("Readiness polling intervals must be greater than zero.")
+	}
+	if _, ok := versionOrderings[o.VersionOrder]; !ok {
+		return fmt.Errorf("Version order %s is invalid.", o.VersionOrder)
+	}
+	for app, order := range o.AppVersionOrders {
+		if _, ok := versionOrderings[order]; !ok {
+			return fmt.Errorf("Version order %s for application %s is invalid.", order, app)
+		}
+	}
+	if _, err := newAppFilter(o.AppIncludes, o.AppExcludes); err != nil {
+		return err
+	}
+	return nil
+}
+
+// validateTarget checks the options of the target managed when there is no targets file.
+func (o *Options) validateTarget() error {
 	if o.Domain == "" {
 		return errors.New("Service domain is mandatory.")
 	}
@@ -39,11 +141,10 @@ func (o *Options) Validate() error {
 	if o.DeployToken == "" {
 		return errors.New("Service deploy authorization token is mandatory.")
 	}
-	if o.ArtAPIEndpoint == "" {
-		return errors.New("Artifactory API endpoint is mandatory.")
-	}
-	if o.ArtUserID == "" {
-		return errors.New("Artifactory API user id is mandatory.")
+	for cluster, url := range o.DeployClusters {
+		if url == "" {
+			return fmt.Errorf("Service deploy URL for cluster %s is mandatory.", cluster)
+		}
 	}
 	if o.ArtDeployRepo == "" {
 		return errors.New("Artifactory API deploy request repo name is mandatory.")
@@ -51,15 +152,62 @@ func (o *Options) Validate() error {
 	if o.ArtPayloadRepo == "" {
 		return errors.New("Artifactory API payload repo is mandatory.")
 	}
-	if o.DSN == "" {
-		return errors.New("DNS database settings are mandatory.")
-	}
 	return nil
 }
 
+// versionOrder returns the version ordering name configured for an application.
+func (o *Options) versionOrder(app string) string {
+	if order, ok := o.AppVersionOrders[app]; ok {
+		return order
+	}
+	return o.VersionOrder
+}
+
 // String is an implentation of the Stringer interface so the structure is returned as a string
 // to fmt.Print() etc.
 func (o *Options) String() string {
 	b, _ := json.Marshal(o)
 	return string(b)
 }
+
+// StringMapValue is a flag.Value that collects comma separated key=value pairs into a map.
+type StringMapValue map[string]string
+
+// String is an implementation of the flag.Value interface.
+func (m *StringMapValue) String() string {
+	pairs := make([]string, 0, len(*m))
+	for k, v := range *m {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Set is an implementation of the flag.Value interface.
+func (m *StringMapValue) Set(value string) error {
+	if *m == nil {
+		*m = make(map[string]string)
+	}
+	for _, pair := range strings.Split(value, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return fmt.Errorf("Invalid key=value pair: %s", pair)
+		}
+		(*m)[kv[0]] = kv[1]
+	}
+	return nil
+}
+
+// StringListValue is a flag.Value that collects the values of a repeated flag into a list.
+type StringListValue []string
+
+// String is an implementation of the flag.Value interface.
+func (l *StringListValue) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Set is an implementation of the flag.Value interface.
+func (l *StringListValue) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
@@ -0,0 +1,273 @@
+package server
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// payloadCache keeps the verified payloads on disk by SHA-256 checksum, so retries, rollbacks and redeploys
+// of a payload do not download it again. The least recently used payloads are evicted to stay within the
+// maximum size.
+type payloadCache struct {
+	mu        sync.Mutex
+	dir       string                   // The directory of the cached payloads.
+	maxSize   int64                    // The maximum size of the cached payloads in bytes. <= 0 disables the cache.
+	entries   map[string]*list.Element // The cached payloads by checksum.
+	lru       *list.List               // The cached payloads, most recently used first.
+	size      int64                    // The size of the cached payloads in bytes.
+	hits      int64                    // How many payloads were found in the cache.
+	misses    int64                    // How many payloads were not found in the cache.
+	evictions int64                    // How many payloads were evicted to stay within the maximum size.
+}
+
+// payloadCacheEntry is a cached payload.
+type payloadCacheEntry struct {
+	checksum string // The SHA-256 hex of the payload.
+	size     int64  // The size of the payload in bytes.
+}
+
+// PayloadCacheStatus contains runtime statistics of the payload cache.
+type PayloadCacheStatus struct {
+	Dir       string `json:"dir"`       // The directory of the cached payloads.
+	Payloads  int    `json:"payloads"`  // How many payloads are cached.
+	Bytes     int64  `json:"bytes"`     // The size of the cached payloads.
+	MaxBytes  int64  `json:"maxBytes"`  // The maximum size of the cached payloads. <= 0 is disabled.
+	Hits      int64  `json:"hits"`      // How many payloads were found in the cache.
+	Misses    int64  `json:"misses"`    // How many payloads were not found in the cache.
+	Evictions int64  `json:"evictions"` // How many payloads were evicted to stay within the maximum size.
+}
+
+// newPayloadCache is a factory function that returns an empty payloadCache.
+func newPayloadCache(dir string, maxSize int64) *payloadCache {
+	return &payloadCache{
+		dir:     dir,
+		maxSize: maxSize,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// load adds the payloads already in the cache directory, most recently modified first, evicting any beyond
+// the maximum size. Their checksums are verified when they are used. Temporary files left by an interrupted
+// put are deleted.
+func (c *payloadCache) load() error {
+	if c.maxSize <= 0 {
+		return nil
+	}
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		return err
+	}
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	sort.Sort(byModTime(files))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, fi := range files {
+		if i := strings.Index(fi.Name(), "."); i >= 0 && validChecksum(fi.Name()[:i]) {
+			os.Remove(filepath.Join(c.dir, fi.Name()))
+			continue
+		}
+		if !validChecksum(fi.Name()) || !fi.Mode().IsRegular() {
+			continue
+		}
+		if _, ok := c.entries[fi.Name()]; !ok {
+			c.entries[fi.Name()] = c.lru.PushBack(&payloadCacheEntry{checksum: fi.Name(), size: fi.Size()})
+			c.size += fi.Size()
+		}
+	}
+	c.evict()
+	return nil
+}
+
+// byModTime sorts files most recently modified first.
+type byModTime []os.FileInfo
+
+func (f byModTime) Len() int           { return len(f) }
+func (f byModTime) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+func (f byModTime) Less(i, j int) bool { return f[i].ModTime().After(f[j].ModTime()) }
+
+// validChecksum returns true if the checksum is a SHA-256 hex, and so safe as a file name.
+func validChecksum(checksum string) bool {
+	b, err := hex.DecodeString(checksum)
+	return err == nil && len(b) == sha256.Size
+}
+
+// path returns the file of a cached payload.
+func (c *payloadCache) path(checksum string) string {
+	return filepath.Join(c.dir, checksum)
+}
+
+// get links or copies the cached payload with the checksum to the file path, and verifies its checksum so a
+// corrupted or tampered file is never used. False is returned if it is not cached.
+func (c *payloadCache) get(checksum string, filePath string) bool {
+	if c.maxSize <= 0 || !validChecksum(checksum) {
+		return false
+	}
+	c.mu.Lock()
+	_, ok := c.entries[checksum]
+	c.mu.Unlock()
+
+	// The file is linked or copied, and hashed, without holding the lock.
+	if ok {
+		if err := linkOrCopy(c.path(checksum), filePath); err == nil {
+			if sum, err := fileChecksum(filePath); err == nil && sum == checksum {
+				c.mu.Lock()
+				defer c.mu.Unlock()
+				if el, ok := c.entries[checksum]; ok {
+					c.lru.MoveToFront(el)
+				}
+				c.hits++
+				return true
+			}
+			os.Remove(filePath)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// The file is gone, unreadable or changed, so it is no longer cached.
+	if el, found := c.entries[checksum]; ok && found {
+		c.remove(el)
+	}
+	c.misses++
+	return false
+}
+
+// put adds the verified payload at the file path to the cache, then evicts the least recently used payloads
+// beyond the maximum size. The file is linked or copied to a temporary file without holding the lock.
+func (c *payloadCache) put(checksum string, filePath string) error {
+	if c.maxSize <= 0 || !validChecksum(checksum) {
+		return nil
+	}
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		return err
+	}
+	if fi.Size() > c.maxSize {
+		return nil // Never fits.
+	}
+	c.mu.Lock()
+	el, ok := c.entries[checksum]
+	if ok {
+		c.lru.MoveToFront(el)
+	}
+	c.mu.Unlock()
+	if ok {
+		return nil
+	}
+
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(c.dir, checksum+".")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	if err := linkOrCopy(filePath, tmp.Name()); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[checksum]; ok {
+		return nil // Cached by another job meanwhile.
+	}
+	if err := os.Rename(tmp.Name(), c.path(checksum)); err != nil {
+		return err
+	}
+	c.entries[checksum] = c.lru.PushFront(&payloadCacheEntry{checksum: checksum, size: fi.Size()})
+	c.size += fi.Size()
+	c.evict()
+	return nil
+}
+
+// fileChecksum returns the SHA-256 hex of a file.
+func fileChecksum(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// evict removes the least recently used payloads until the cache is within its maximum size.
+func (c *payloadCache) evict() {
+	for c.size > c.maxSize && c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+		c.evictions++
+	}
+}
+
+// remove deletes a cached payload.
+func (c *payloadCache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*payloadCacheEntry)
+	delete(c.entries, e.checksum)
+	c.size -= e.size
+	os.Remove(c.path(e.checksum))
+}
+
+// purge deletes every cached payload and returns how many were deleted.
+func (c *payloadCache) purge() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.lru.Len()
+	for c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+	return n
+}
+
+// status returns a snapshot of the cache statistics.
+func (c *payloadCache) status() *PayloadCacheStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return &PayloadCacheStatus{
+		Dir:       c.dir,
+		Payloads:  c.lru.Len(),
+		Bytes:     c.size,
+		MaxBytes:  c.maxSize,
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+	}
+}
+
+// linkOrCopy hard links a file to a new path, or copies it if it cannot be linked ex: across devices.
+func linkOrCopy(src string, dst string) error {
+	os.Remove(dst)
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestCachePayload writes a payload of size bytes and returns its path and checksum.
+func newTestCachePayload(t *testing.T, dir string, name string, size int) (string, string) {
+	b := []byte(strings.Repeat(name[:1], size))
+	filePath := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(filePath, b, 0644); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	sum := sha256.Sum256(b)
+	return filePath, hex.EncodeToString(sum[:])
+}
+
+func TestPayloadCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "payload-cache")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	c := newPayloadCache(filepath.Join(dir, "cache"), 250)
+
+	a, aSum := newTestCachePayload(t, dir, "a.tar.gz", 100)
+	b, bSum := newTestCachePayload(t, dir, "b.tar.gz", 100)
+	d, dSum := newTestCachePayload(t, dir, "d.tar.gz", 100)
+	big, bigSum := newTestCachePayload(t, dir, "big.tar.gz", 300)
+	dest := filepath.Join(dir, "dest.tar.gz")
+
+	if c.get(aSum, dest) {
+		t.Errorf("Expected a miss from an empty cache.")
+	}
+	for _, p := range [][2]string{{a, aSum}, {b, bSum}, {big, bigSum}} {
+		if err := c.put(p[1], p[0]); err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+	}
+	if c.get(bigSum, dest) {
+		t.Errorf("Expected a payload larger than the cache not to be cached.")
+	}
+	if !c.get(aSum, dest) {
+		t.Fatalf("Expected a hit for a cached payload.")
+	}
+	if got, _ := ioutil.ReadFile(dest); len(got) != 100 || got[0] != 'a' {
+		t.Errorf("Unexpected cached payload: %q", got)
+	}
+
+	// a was used more recently than b, so b is evicted.
+	if err := c.put(dSum, d); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if c.get(bSum, dest) {
+		t.Errorf("Expected the least recently used payload to be evicted.")
+	}
+	if _, err := os.Stat(c.path(bSum)); !os.IsNotExist(err) {
+		t.Errorf("Expected the evicted payload to be deleted.")
+	}
+	if !c.get(aSum, dest) || !c.get(dSum, dest) {
+		t.Errorf("Expected the recently used payloads to be cached.")
+	}
+	st := c.status()
+	if st.Payloads != 2 || st.Bytes != 200 || st.MaxBytes != 250 || st.Hits != 3 || st.Misses != 3 ||
+		st.Evictions != 1 {
+		t.Errorf("Unexpected status: %+v", st)
+	}
+
+	// A payload changed in the directory is never used, and is no longer cached.
+	os.Remove(dest)
+	ioutil.WriteFile(c.path(dSum), []byte(strings.Repeat("x", 100)), 0600)
+	if c.get(dSum, dest) || c.status().Payloads != 1 {
+		t.Errorf("Expected a tampered payload to be dropped from the cache.")
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Errorf("Expected a tampered payload not to be left at the destination.")
+	}
+
+	// A payload deleted from the directory is no longer cached.
+	os.Remove(c.path(aSum))
+	if c.get(aSum, dest) || c.status().Payloads != 0 {
+		t.Errorf("Expected a deleted payload to be dropped from the cache.")
+	}
+	c.put(aSum, a)
+
+	// Unsafe checksums are never cached.
+	if err := c.put("../"+aSum[3:], a); err != nil || c.status().Payloads != 1 {
+		t.Errorf("Expected an invalid checksum not to be cached: %v", err)
+	}
+
+	if n := c.purge(); n != 1 {
+		t.Errorf("Expected 1 payload purged, received %d", n)
+	}
+	if files, _ := ioutil.ReadDir(c.dir); len(files) != 0 || c.status().Bytes != 0 {
+		t.Errorf("Expected an empty cache after a purge.")
+	}
+
+	// A disabled cache keeps nothing.
+	off := newPayloadCache(filepath.Join(dir, "off"), 0)
+	if err := off.put(aSum, a); err != nil || off.get(aSum, dest) {
+		t.Errorf("Expected a disabled cache to keep nothing: %v", err)
+	}
+	if _, err := os.Stat(off.dir); !os.IsNotExist(err) {
+		t.Errorf("Expected a disabled cache not to create its directory.")
+	}
+}
+
+func TestPayloadCacheLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "payload-cache")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	// Payloads left by a previous run are loaded, most recently modified first.
+	var sums []string
+	for i, name := range []string{"a", "b", "d"} {
+		b := []byte(strings.Repeat(name, 100))
+		sum := sha256.Sum256(b)
+		sums = append(sums, hex.EncodeToString(sum[:]))
+		filePath := filepath.Join(dir, sums[i])
+		ioutil.WriteFile(filePath, b, 0600)
+		mtime := time.Now().Add(time.Duration(i-10) * time.Minute)
+		os.Chtimes(filePath, mtime, mtime)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("x"), 0600)
+	ioutil.WriteFile(filepath.Join(dir, sums[0]+".123"), []byte("partial"), 0600)
+
+	c := newPayloadCache(dir, 250)
+	if err := c.load(); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	st := c.status()
+	if st.Payloads != 2 || st.Bytes != 200 || st.Evictions != 1 {
+		t.Errorf("Unexpected status: %+v", st)
+	}
+	dest := filepath.Join(dir, "dest.tar.gz")
+	if c.get(sums[0], dest) || !c.get(sums[1], dest) || !c.get(sums[2], dest) {
+		t.Errorf("Expected the oldest payload to be evicted on load.")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes.txt")); err != nil {
+		t.Errorf("Expected other files to be left alone.")
+	}
+	if _, err := os.Stat(filepath.Join(dir, sums[0]+".123")); !os.IsNotExist(err) {
+		t.Errorf("Expected temporary files to be deleted on load.")
+	}
+}
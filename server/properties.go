@@ -0,0 +1,184 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// PropertyRules gate deploys on the artifactory properties of the payloads. It is loaded from the JSON file of
+// --properties_file. A version is only deployed if its payload has every property required by the rules covering it.
+type PropertyRules struct {
+	Rules []*PropertyRule `json:"rules"` // The required properties by environment and application.
+}
+
+// PropertyRule lists the properties a payload needs before it is deployed.
+type PropertyRule struct {
+	Environment string            `json:"environment"` // The environment covered. Empty is every environment.
+	Apps        []string          `json:"apps"`        // The applications covered. Empty is every application.
+	Properties  map[string]string `json:"properties"`  // The required values by property name. "*" is any value.
+}
+
+// ArtItemProperties is the result of an artifactory storage API ?properties request.
+type ArtItemProperties struct {
+	Uri        string              `json:"uri"`        // The API uri of the item.
+	Properties map[string][]string `json:"properties"` // The values of each property of the item.
+}
+
+// loadPropertyRules reads and validates a property rules file.
+func loadPropertyRules(filePath string) (*PropertyRules, error) {
+	b, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read properties file %s: %s", filePath, err.Error())
+	}
+	pr, err := parsePropertyRules(b)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid properties file %s: %s", filePath, err.Error())
+	}
+	return pr, nil
+}
+
+// parsePropertyRules parses and validates the JSON of the property rules.
+func parsePropertyRules(b []byte) (*PropertyRules, error) {
+	pr := &PropertyRules{}
+	if err := json.Unmarshal(b, pr); err != nil {
+		return nil, err
+	}
+	for _, r := range pr.Rules {
+		if len(r.Properties) == 0 {
+			return nil, errors.New("Every rule must require at least one property.")
+		}
+		for name := range r.Properties {
+			if name == "" {
+				return nil, errors.New("Property names cannot be empty.")
+			}
+		}
+	}
+	return pr, nil
+}
+
+// required returns the properties required of the payloads of an application in an environment. A nil rule
+// set requires nothing.
+func (pr *PropertyRules) required(env string, app string) map[string]string {
+	if pr == nil {
+		return nil
+	}
+	result := make(map[string]string)
+	for _, r := range pr.Rules {
+		if !scheduleCovers(r.Environment, r.Apps, env, app) {
+			continue
+		}
+		for name, value := range r.Properties {
+			result[name] = value
+		}
+	}
+	return result
+}
+
+// missingProperties returns the required properties not matched by the properties of an item, sorted by name,
+// ex: ["deploy.approved=true"]
+func missingProperties(required map[string]string, props map[string][]string) []string {
+	missing := make([]string, 0)
+	for name, value := range required {
+		found := false
+		for _, v := range props[name] {
+			if value == propertyAnyValue || v == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, fmt.Sprintf("%s=%s", name, value))
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// getArtPayloadProperties returns the properties of the payload of a version. A payload without properties
+// has none, rather than an error.
+func (s *Server) getArtPayloadProperties(t *Target, name string, version string) (map[string][]string, error) {
+	// Payload file name ex: foo.com-development-video-mobile-1.0.1-23.tar.gz
+	itemPath := fmt.Sprintf("%s/%s/%s-%s-%s-%s.tar.gz", t.ArtPayloadRepo, name, t.Domain, t.Environment, name,
+		version)
+	// evaluates as "http://art.com/foo/api" + "/storage" + "/" + "payloadrepo/appname/foo.tar.gz" + "?properties"
+	req, err := s.newArtRequest(httpGet, fmt.Sprintf("%s%s/%s?properties", s.opts.ArtAPIEndpoint, artSourceRoute,
+		itemPath), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.sendRequest(req)
+	if err != nil {
+		// Artifactory answers not found if the item has no properties.
+		if ae, ok := err.(*ArtifactoryError); ok && ae.StatusCode == http.StatusNotFound {
+			return map[string][]string{}, nil
+		}
+		return nil, err
+	}
+	var ip ArtItemProperties
+	if err := json.Unmarshal([]byte(resp), &ip); err != nil {
+		return nil, err
+	}
+	if ip.Properties == nil {
+		ip.Properties = map[string][]string{}
+	}
+	return ip.Properties, nil
+}
+
+// blockedDeploys holds the versions of a target not deployed because their payloads are missing required
+// properties.
+type blockedDeploys struct {
+	mu       sync.Mutex
+	versions map[string][]*BlockedDeployStatus // The blocked versions by application name.
+}
+
+// BlockedDeployStatus describes a version blocked by the property rules.
+type BlockedDeployStatus struct {
+	Domain      string    `json:"domain"`      // The domain to deploy to.
+	Environment string    `json:"environment"` // The environment to deploy to.
+	Name        string    `json:"name"`        // The application name.
+	Version     string    `json:"version"`     // The version blocked.
+	Missing     []string  `json:"missing"`     // The required properties missing from the payload.
+	CheckedAt   time.Time `json:"checkedAt"`   // When the properties were last checked.
+}
+
+// newBlockedDeploys is a factory function that returns an empty blockedDeploys.
+func newBlockedDeploys() *blockedDeploys {
+	return &blockedDeploys{versions: make(map[string][]*BlockedDeployStatus)}
+}
+
+// update replaces the blocked versions with the result of a check. If appName is not empty, only that
+// application was checked.
+func (b *blockedDeploys) update(appName string, blocked map[string][]*BlockedDeployStatus) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for name := range b.versions {
+		if appName == "" || appName == name {
+			delete(b.versions, name)
+		}
+	}
+	for name, versions := range blocked {
+		b.versions[name] = versions
+	}
+}
+
+// status returns a snapshot of the blocked versions sorted by application name.
+func (b *blockedDeploys) status() []*BlockedDeployStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	names := make([]string, 0, len(b.versions))
+	for name := range b.versions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	result := make([]*BlockedDeployStatus, 0)
+	for _, name := range names {
+		result = append(result, b.versions[name]...)
+	}
+	return result
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testPropertyRules = `{
+  "rules": [
+    {"environment": "production", "properties": {"deploy.approved": "true", "qa.passed": "true"}},
+    {"apps": ["video-mobile"], "properties": {"build.ticket": "*"}}
+  ]
+}`
+
+func TestPropertyRulesRequired(t *testing.T) {
+	pr, err := parsePropertyRules([]byte(testPropertyRules))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	tests := []struct {
+		env      string
+		app      string
+		required map[string]string
+	}{
+		{"production", "search-api", map[string]string{"deploy.approved": "true", "qa.passed": "true"}},
+		{"production", "video-mobile", map[string]string{"deploy.approved": "true", "qa.passed": "true",
+			"build.ticket": "*"}},
+		{"development", "video-mobile", map[string]string{"build.ticket": "*"}},
+		{"development", "search-api", map[string]string{}},
+	}
+	for _, tc := range tests {
+		if actual := pr.required(tc.env, tc.app); !reflect.DeepEqual(actual, tc.required) {
+			t.Errorf("%s %s: expected %v, received %v", tc.env, tc.app, tc.required, actual)
+		}
+	}
+
+	var none *PropertyRules
+	if len(none.required("production", "search-api")) != 0 {
+		t.Errorf("A nil rule set should require nothing.")
+	}
+
+	for _, content := range []string{`{"rules": [{"environment": "production"}]}`,
+		`{"rules": [{"properties": {"": "true"}}]}`, `{"rules": {}}`} {
+		if _, err := parsePropertyRules([]byte(content)); err == nil {
+			t.Errorf("Expected an error parsing %s.", content)
+		}
+	}
+}
+
+func TestMissingProperties(t *testing.T) {
+	required := map[string]string{"deploy.approved": "true", "qa.passed": "true", "build.ticket": "*"}
+	tests := []struct {
+		props   map[string][]string
+		missing []string
+	}{
+		{map[string][]string{"deploy.approved": {"true"}, "qa.passed": {"false", "true"}, "build.ticket": {"CHG-1"}},
+			[]string{}},
+		{map[string][]string{"deploy.approved": {"false"}, "build.ticket": {""}},
+			[]string{"deploy.approved=true", "qa.passed=true"}},
+		{map[string][]string{}, []string{"build.ticket=*", "deploy.approved=true", "qa.passed=true"}},
+	}
+	for i, tc := range tests {
+		if actual := missingProperties(required, tc.props); !reflect.DeepEqual(actual, tc.missing) {
+			t.Errorf("Test %d: expected %v, received %v", i, tc.missing, actual)
+		}
+	}
+}
+
+func TestCheckDeltasProperties(t *testing.T) {
+	files := []*fakeArtFile{
+		{"video-mobile", "1.0.9-40.deploy", "2015-09-01T10:00:00.000Z", "jenkins"},
+		{"video-mobile", "1.0.10-3.deploy", "2015-09-02T10:00:00.000Z", "jenkins"},
+		{"video-mobile", "1.0.11-1.deploy", "2015-09-03T10:00:00.000Z", "jenkins"},
+	}
+	fake, ts := newFakeArtifactory(files)
+	defer ts.Close()
+	fake.mu.Lock()
+	fake.props = map[string]map[string][]string{
+		"example.com-production-video-mobile-1.0.10-3.tar.gz": {"deploy.approved": {"true"}},
+		"example.com-production-video-mobile-1.0.11-1.tar.gz": {"deploy.approved": {"false"}},
+	}
+	fake.mu.Unlock()
+	s := newTestServer(ts.URL, DiscoveryAQL)
+	s.properties, _ = parsePropertyRules([]byte(`{"rules": [{"environment": "production", ` +
+		`"properties": {"deploy.approved": "true"}}]}`))
+	d, raw := newTestDB(t, "properties")
+	defer raw.Close()
+	defer d.Close()
+	s.db = d
+	tm := s.monitors[0]
+	tm.target.Domain, tm.target.Environment, tm.target.ArtPayloadRepo = "example.com", "production", "cluster-payloads"
+	if _, err := raw.Exec("INSERT INTO artifactory_deploys (domain, environment, service_name, version, status, " +
+		"updated_at, created_at) VALUES ('example.com', 'production', 'video-mobile', '1.0.9-40', 2, " +
+		"'2015-09-01 10:00:00', '2015-09-01 10:00:00')"); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	// The latest version is not approved, so the newest approved version is deployed instead.
+	jobs, err := s.checkDeltas(tm, "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if len(jobs) != 1 || jobs[0].Version != "1.0.10-3" {
+		t.Errorf("Expected a job for 1.0.10-3, received %v", jobs)
+	}
+	blocked := tm.status().Blocked
+	if len(blocked) != 1 || blocked[0].Version != "1.0.11-1" ||
+		!reflect.DeepEqual(blocked[0].Missing, []string{"deploy.approved=true"}) {
+		t.Errorf("Expected 1.0.11-1 to be blocked, received %+v", blocked)
+	}
+	if fake.requestCount(httpGet, "/api"+artSourceRoute+"/cluster-payloads/video-mobile/") != 2 {
+		t.Errorf("Expected the properties of two payloads to be read.")
+	}
+
+	// Once every newer version is blocked nothing is deployed. A payload without properties is blocked too.
+	fake.mu.Lock()
+	fake.props = map[string]map[string][]string{}
+	fake.mu.Unlock()
+	jobs, err = s.checkDeltas(tm, "video-mobile")
+	if err != nil || len(jobs) != 0 {
+		t.Errorf("Expected no jobs, received %v (%v)", jobs, err)
+	}
+	if blocked := tm.status().Blocked; len(blocked) != 2 || blocked[0].Version != "1.0.11-1" ||
+		blocked[1].Version != "1.0.10-3" {
+		t.Errorf("Expected 1.0.11-1 and 1.0.10-3 to be blocked, received %+v", blocked)
+	}
+
+	// Other environments are not gated.
+	tm.target.Environment = "development"
+	if jobs, err := s.checkDeltas(tm, ""); err != nil || len(jobs) != 1 || jobs[0].Version != "1.0.11-1" {
+		t.Errorf("Expected a job for 1.0.11-1 in development, received %v (%v)", jobs, err)
+	}
+	if blocked := tm.status().Blocked; len(blocked) != 0 {
+		t.Errorf("Expected nothing blocked in development, received %+v", blocked)
+	}
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DeploySchedule decides when deploys are allowed. It is loaded from the JSON file of --schedule_file.
+// A deploy is allowed when no freeze covers it and, if any window applies to it, one of those windows is open.
+type DeploySchedule struct {
+	Timezone string          `json:"timezone"` // The time zone of the windows ex: America/Los_Angeles (default UTC).
+	Windows  []*DeployWindow `json:"windows"`  // When deploys are allowed.
+	Freezes  []*DeployFreeze `json:"freezes"`  // When deploys are not allowed.
+	location *time.Location  // The loaded time zone.
+}
+
+// DeployWindow is a recurring period when deploys are allowed.
+type DeployWindow struct {
+	Environment string    `json:"environment"` // The environment covered. Empty is every environment.
+	Apps        []string  `json:"apps"`        // The applications covered. Empty is every application.
+	Cron        string    `json:"cron"`        // The minutes the window is open, as a five field cron expression.
+	spec        *cronSpec // The parsed cron expression.
+}
+
+// DeployFreeze is a period when deploys are not allowed.
+type DeployFreeze struct {
+	Environment string    `json:"environment"` // The environment covered. Empty is every environment.
+	Apps        []string  `json:"apps"`        // The applications covered. Empty is every application.
+	Start       time.Time `json:"start"`       // When the freeze starts (RFC3339).
+	End         time.Time `json:"end"`         // When the freeze ends (RFC3339).
+	Reason      string    `json:"reason"`      // Why deploys are frozen.
+}
+
+// loadDeploySchedule reads and validates a deploy schedule file.
+func loadDeploySchedule(filePath string) (*DeploySchedule, error) {
+	b, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read schedule file %s: %s", filePath, err.Error())
+	}
+	sc, err := parseDeploySchedule(b)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid schedule file %s: %s", filePath, err.Error())
+	}
+	return sc, nil
+}
+
+// parseDeploySchedule parses and validates the JSON of a deploy schedule.
+func parseDeploySchedule(b []byte) (*DeploySchedule, error) {
+	sc := &DeploySchedule{}
+	if err := json.Unmarshal(b, sc); err != nil {
+		return nil, err
+	}
+	sc.location = time.UTC
+	if sc.Timezone != "" {
+		loc, err := time.LoadLocation(sc.Timezone)
+		if err != nil {
+			return nil, err
+		}
+		sc.location = loc
+	}
+	for _, w := range sc.Windows {
+		spec, err := parseCron(w.Cron)
+		if err != nil {
+			return nil, err
+		}
+		w.spec = spec
+	}
+	for _, f := range sc.Freezes {
+		if !f.End.After(f.Start) {
+			return nil, fmt.Errorf("Freeze %q must end after it starts.", f.Reason)
+		}
+	}
+	return sc, nil
+}
+
+// allowed returns true if a deploy of the application in the environment is allowed at time t.
+// If not, the reason is returned. A nil schedule allows every deploy.
+func (sc *DeploySchedule) allowed(env string, app string, t time.Time) (bool, string) {
+	if sc == nil {
+		return true, ""
+	}
+	for _, f := range sc.Freezes {
+		if scheduleCovers(f.Environment, f.Apps, env, app) && !t.Before(f.Start) && t.Before(f.End) {
+			return false, fmt.Sprintf("Frozen until %s: %s", f.End.Format(time.RFC3339), f.Reason)
+		}
+	}
+	covered := false
+	local := t.In(sc.location)
+	for _, w := range sc.Windows {
+		if !scheduleCovers(w.Environment, w.Apps, env, app) {
+			continue
+		}
+		if w.spec.matches(local) {
+			return true, ""
+		}
+		covered = true
+	}
+	if covered {
+		return false, "Outside of the deploy windows"
+	}
+	return true, ""
+}
+
+// scheduleCovers returns true if a window or freeze for the environment and applications covers an application.
+func scheduleCovers(scEnv string, scApps []string, env string, app string) bool {
+	if scEnv != "" && scEnv != env {
+		return false
+	}
+	if len(scApps) == 0 {
+		return true
+	}
+	for _, a := range scApps {
+		if a == app {
+			return true
+		}
+	}
+	return false
+}
+
+// pendingDeploys holds the deploy jobs of a target not allowed by the schedule until a window opens. Only the
+// newest job detected for an application is held.
+type pendingDeploys struct {
+	mu   sync.Mutex
+	jobs map[string]*pendingDeploy // The held jobs by application name.
+}
+
+// pendingDeploy is a deploy job held by the schedule.
+type pendingDeploy struct {
+	job        *DeployWorker // The held job.
+	detectedAt time.Time     // When the job was first held.
+	reason     string        // Why the job is held.
+}
+
+// PendingDeployStatus describes a deploy job held by the schedule.
+type PendingDeployStatus struct {
+	Domain      string    `json:"domain"`      // The domain to deploy to.
+	Environment string    `json:"environment"` // The environment to deploy to.
+	Name        string    `json:"name"`        // The application name.
+	Version     string    `json:"version"`     // The version to deploy.
+	DetectedAt  time.Time `json:"detectedAt"`  // When the deploy was first held.
+	Reason      string    `json:"reason"`      // Why the deploy is held.
+}
+
+// newPendingDeploys is a factory function that returns an empty pendingDeploys.
+func newPendingDeploys() *pendingDeploys {
+	return &pendingDeploys{jobs: make(map[string]*pendingDeploy)}
+}
+
+// hold keeps a job until it is released. It returns true if the job was not already held.
+func (p *pendingDeploys) hold(d *DeployWorker, reason string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pd, ok := p.jobs[d.Name]
+	if ok && pd.job.Version == d.Version {
+		pd.reason = reason
+		return false
+	}
+	p.jobs[d.Name] = &pendingDeploy{job: d, detectedAt: time.Now(), reason: reason}
+	return true
+}
+
+// prune drops the held jobs of applications that no longer have a delta. If appName is not empty,
+// only that application is checked.
+func (p *pendingDeploys) prune(appName string, held map[string]bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for name := range p.jobs {
+		if (appName == "" || appName == name) && !held[name] {
+			delete(p.jobs, name)
+		}
+	}
+}
+
+// release removes and returns the held jobs that are allowed to run.
+func (p *pendingDeploys) release(allowed func(d *DeployWorker) bool) []*DeployWorker {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	released := make([]*DeployWorker, 0)
+	for name, pd := range p.jobs {
+		if allowed(pd.job) {
+			released = append(released, pd.job)
+			delete(p.jobs, name)
+		}
+	}
+	return released
+}
+
+// status returns a snapshot of the held jobs sorted by application name.
+func (p *pendingDeploys) status() []*PendingDeployStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	result := make([]*PendingDeployStatus, 0, len(p.jobs))
+	for _, pd := range p.jobs {
+		js := pd.job.jobStatus()
+		result = append(result, &PendingDeployStatus{
+			Domain:      js.Domain,
+			Environment: js.Environment,
+			Name:        js.Name,
+			Version:     js.Version,
+			DetectedAt:  pd.detectedAt,
+			Reason:      pd.reason,
+		})
+	}
+	sort.Sort(pendingDeployStatusByName(result))
+	return result
+}
+
+// pendingDeployStatusByName sorts pending deploy statuses by application name.
+type pendingDeployStatusByName []*PendingDeployStatus
+
+func (a pendingDeployStatusByName) Len() int           { return len(a) }
+func (a pendingDeployStatusByName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a pendingDeployStatusByName) Less(i, j int) bool { return a[i].Name < a[j].Name }
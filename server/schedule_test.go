@@ -0,0 +1,103 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+const testSchedule = `{
+  "timezone": "America/New_York",
+  "windows": [
+    {"environment": "production", "cron": "* 9-16 * * 1-5"},
+    {"environment": "production", "apps": ["batch-jobs"], "cron": "* 2-3 * * *"}
+  ],
+  "freezes": [
+    {"environment": "production", "start": "2026-12-20T00:00:00Z", "end": "2027-01-04T00:00:00Z",
+     "reason": "Holiday freeze"},
+    {"apps": ["video-mobile"], "start": "2026-10-19T00:00:00Z", "end": "2026-10-20T00:00:00Z",
+     "reason": "Launch"}
+  ]
+}`
+
+func TestDeployScheduleAllowed(t *testing.T) {
+	sc, err := parseDeploySchedule([]byte(testSchedule))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	tests := []struct {
+		env     string
+		app     string
+		time    string
+		allowed bool
+	}{
+		{"production", "search-api", "2026-10-21T14:00:00Z", true},     // Wednesday 10:00 in New York.
+		{"production", "search-api", "2026-10-21T12:00:00Z", false},    // Wednesday 08:00 in New York.
+		{"production", "batch-jobs", "2026-10-24T06:30:00Z", true},     // Saturday 02:30 in New York.
+		{"production", "search-api", "2026-10-24T06:30:00Z", false},    // Only batch-jobs is open.
+		{"production", "search-api", "2026-12-22T15:00:00Z", false},    // Holiday freeze.
+		{"development", "search-api", "2026-12-22T03:00:00Z", true},    // No windows or freezes.
+		{"development", "video-mobile", "2026-10-19T03:00:00Z", false}, // Launch freeze.
+		{"development", "video-mobile", "2026-10-20T00:00:00Z", true},  // The freeze has ended.
+	}
+	for _, tc := range tests {
+		at, _ := time.Parse(time.RFC3339, tc.time)
+		ok, reason := sc.allowed(tc.env, tc.app, at)
+		if ok != tc.allowed {
+			t.Errorf("%s %s at %s: expected %t, received %t (%s).", tc.env, tc.app, tc.time, tc.allowed, ok, reason)
+		}
+		if !ok && reason == "" {
+			t.Errorf("%s %s at %s: expected a reason.", tc.env, tc.app, tc.time)
+		}
+	}
+
+	var none *DeploySchedule
+	if ok, _ := none.allowed("production", "search-api", time.Now()); !ok {
+		t.Errorf("A nil schedule should allow every deploy.")
+	}
+}
+
+func TestDeployScheduleInvalid(t *testing.T) {
+	for _, content := range []string{
+		`{"timezone": "Nowhere/Special"}`,
+		`{"windows": [{"cron": "* * *"}]}`,
+		`{"freezes": [{"start": "2026-10-20T00:00:00Z", "end": "2026-10-19T00:00:00Z"}]}`,
+		`{"windows": {}}`,
+	} {
+		if _, err := parseDeploySchedule([]byte(content)); err == nil {
+			t.Errorf("Expected an error parsing %s.", content)
+		}
+	}
+}
+
+func TestPendingDeploys(t *testing.T) {
+	p := newPendingDeploys()
+	if !p.hold(&DeployWorker{Name: "video-mobile", Version: "1.0.1-1"}, "Frozen") ||
+		!p.hold(&DeployWorker{Name: "search-api", Version: "2.0.0-1"}, "Frozen") {
+		t.Errorf("Expected new jobs to be held.")
+	}
+	if p.hold(&DeployWorker{Name: "video-mobile", Version: "1.0.1-1"}, "Closed") {
+		t.Errorf("Expected the same version to be held already.")
+	}
+	if !p.hold(&DeployWorker{Name: "video-mobile", Version: "1.0.1-2"}, "Closed") {
+		t.Errorf("Expected a newer version to replace the held job.")
+	}
+	st := p.status()
+	if len(st) != 2 || st[0].Name != "search-api" || st[1].Version != "1.0.1-2" || st[1].Reason != "Closed" {
+		t.Errorf("Unexpected pending deploys: %+v", st)
+	}
+
+	// A check of one application only prunes that application.
+	p.prune("video-mobile", map[string]bool{})
+	if st := p.status(); len(st) != 1 || st[0].Name != "search-api" {
+		t.Errorf("Expected only search-api to remain pending.")
+	}
+
+	p.hold(&DeployWorker{Name: "video-mobile", Version: "1.0.1-3"}, "Closed")
+	released := p.release(func(d *DeployWorker) bool { return d.Name == "video-mobile" })
+	if len(released) != 1 || released[0].Version != "1.0.1-3" {
+		t.Errorf("Expected video-mobile 1.0.1-3 to be released.")
+	}
+	if st := p.status(); len(st) != 1 || st[0].Name != "search-api" {
+		t.Errorf("Expected only search-api to remain pending after the release.")
+	}
+}
@@ -3,6 +3,7 @@ package server
 
 import (
 	"bytes"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -28,14 +29,25 @@ type Server struct {
 	mu sync.RWMutex   // For locking access to server attributes.
 	wg sync.WaitGroup // Synchronize shutdown pending jobs.
 
-	running bool           // Is the server running?
-	done    chan bool      // A channel to signal to the monitor to stop run.
-	force   chan bool      // A channel to signal to the monitor to look for deploys.
-	opts    *Options       // Original options used to create the server.
-	db      *db.DBConnect  // Database connection
-	stats   *Status        // Server statistics since it started.
-	srvr    *http.Server   // HTTP server.
-	log     *logger.Logger // Log instance for recording error and other messages.
+	running    bool             // Is the server running?
+	done       chan bool        // A channel to signal to the monitor to stop run.
+	opts       *Options         // Original options used to create the server.
+	db         *db.DBConnect    // Database connection
+	stats      *Status          // Server statistics since it started.
+	srvr       *http.Server     // HTTP server.
+	client     *httpClient      // HTTP client for outbound requests.
+	artAuth    artAuthenticator // Adds credentials to artifactory requests.
+	deploys    *deployPool      // Runs the deploy jobs.
+	pipelines  *deployPipelines // Serializes the deploy jobs of each application.
+	schedule   *DeploySchedule  // When deploys are allowed. Nil allows every deploy.
+	properties *PropertyRules   // The payload properties required to deploy. Nil requires none.
+	signatures *SignaturePolicy // The keys trusted to sign payloads. Nil verifies no signatures.
+	folders    *folderCache     // The last listing of each artifactory folder.
+	payloads   *payloadCache    // The verified payloads by checksum.
+	filter     *AppFilter       // The applications managed. Nil manages every application.
+	monitors   []*targetMonitor // The monitors of the targets managed.
+	ready      *readinessCache  // The last result of the readiness checks.
+	log        *logger.Logger   // Log instance for recording error and other messages.
 }
 
 // New is a factory function that returns a new server instance.
@@ -49,17 +61,30 @@ func New(ops *Options, l *logger.Logger) *Server {
 
 	// Clean up options before running.
 	s.opts.ArtAPIEndpoint = strings.TrimRight(strings.Trim(s.opts.ArtAPIEndpoint, " "), "/")
-	s.opts.DeployURL = strings.TrimRight(strings.Trim(s.opts.DeployURL, " "), "/")
 	if s.opts.Debug {
 		s.log.SetLogLevel(logger.Debug)
 	}
+	s.client = newHTTPClient(s.opts, s.log)
+	s.artAuth = newArtAuthenticator(s.opts, s.client)
+	s.deploys = newDeployPool(s.opts.MaxDeploys)
+	s.pipelines = newDeployPipelines(s.deploys, s.log)
+	s.folders = newFolderCache()
+	s.ready = &readinessCache{}
+	s.payloads = newPayloadCache(s.opts.PayloadCacheDir, int64(s.opts.PayloadCacheSize)*1024*1024)
+	s.monitors = []*targetMonitor{newTargetMonitor(optionsTarget(s.opts))}
 
 	// Setup the routes and server.
 	mux := http.NewServeMux()
 	mux.HandleFunc(httpRouteV1Health, s.healthHandler)
+	mux.HandleFunc(httpRouteV1HealthLive, s.healthHandler)
+	mux.HandleFunc(httpRouteV1HealthReady, s.readinessHandler)
 	mux.HandleFunc(httpRouteV1Info, s.infoHandler)
 	mux.HandleFunc(httpRouteV1Metrics, s.metricsHandler)
 	mux.HandleFunc(httpRouteV1Force, s.forceHandler)
+	mux.HandleFunc(httpRouteV1PayloadCache, s.payloadCacheHandler)
+	mux.HandleFunc(httpRouteV1Targets, s.targetsHandler)
+	mux.HandleFunc(httpRouteV1Deploys, s.deploysHandler)
+	mux.HandleFunc(httpRouteV1ArtWebhook, s.artWebhookHandler)
 	s.srvr = &http.Server{
 		Addr:         fmt.Sprintf("%s:%d", s.opts.HostName, s.opts.Port),
 		Handler:      &Middleware{serv: s, handler: mux},
@@ -83,12 +108,68 @@ func (s *Server) Start() error {
 
 	s.log.Infof("Starting coreos-artifactory-monitor version %s\n", version)
 	s.handleSignals()
+	setDefaultTransportTimeouts(s.opts)
+	if err := setDefaultTransportTLS(s.opts); err != nil {
+		return err
+	}
 	if err := os.MkdirAll(tmpDir, 0744); err != nil {
 		return err
 	}
+	if err := s.payloads.load(); err != nil {
+		return err
+	}
 
 	s.mu.Lock()
 
+	// Load the deploy schedule.
+	if s.opts.ScheduleFile != "" {
+		sc, err := loadDeploySchedule(s.opts.ScheduleFile)
+		if err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		s.schedule = sc
+	}
+
+	// Load the payload properties required.
+	if s.opts.PropertiesFile != "" {
+		pr, err := loadPropertyRules(s.opts.PropertiesFile)
+		if err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		s.properties = pr
+	}
+
+	// Load the keys trusted to sign payloads.
+	if s.opts.SignaturesFile != "" {
+		sp, err := loadSignaturePolicy(s.opts.SignaturesFile)
+		if err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		s.signatures = sp
+	}
+
+	// Load the application filter.
+	if err := s.loadAppFilter(); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+
+	// Load the targets managed.
+	if s.opts.TargetsFile != "" {
+		targets, err := loadTargets(s.opts.TargetsFile, s.opts)
+		if err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		s.monitors = make([]*targetMonitor, 0, len(targets))
+		for _, t := range targets {
+			s.monitors = append(s.monitors, newTargetMonitor(t))
+		}
+	}
+
 	// Connect to db
 	db, err := db.NewDBConnect(s.opts.DSN)
 	if err != nil {
@@ -105,9 +186,10 @@ func (s *Server) Start() error {
 	s.stats.Start = time.Now()
 	s.running = true
 	s.done = make(chan bool)
-	s.force = make(chan bool)
 	s.mu.Unlock()
-	go s.Monitor()
+	for _, tm := range s.monitors {
+		go s.Monitor(tm)
+	}
 	err = s.srvr.ListenAndServe()
 	if err != nil {
 		s.log.Emergencyf("Listen and Server Error: %s", err.Error())
@@ -120,6 +202,18 @@ func (s *Server) Start() error {
 	return nil
 }
 
+// loadAppFilter builds the filter of the applications managed from the options and the filter file.
+func (s *Server) loadAppFilter() error {
+	var err error
+	switch {
+	case s.opts.FilterFile != "":
+		s.filter, err = loadAppFilter(s.opts.FilterFile, s.opts.AppIncludes, s.opts.AppExcludes)
+	case len(s.opts.AppIncludes) > 0 || len(s.opts.AppExcludes) > 0:
+		s.filter, err = newAppFilter(s.opts.AppIncludes, s.opts.AppExcludes)
+	}
+	return err
+}
+
 // StartProfiler is called to enable dynamic profiling.
 func (s *Server) StartProfiler() {
 	s.log.Infof("Starting profiling on http port %d", s.opts.ProfPort)
@@ -141,11 +235,17 @@ func (s *Server) Shutdown() {
 	s.mu.Lock()
 	s.srvr.SetKeepAlivesEnabled(false)
 	close(s.done)
+	s.mu.Unlock()
+
+	// Let the monitor and the running deploy jobs finish. Jobs not started are dropped.
 	s.wg.Wait()
+	s.pipelines.Stop()
+	s.deploys.Wait()
+
+	s.mu.Lock()
 	if s.db != nil {
 		s.db.Close()
 	}
-	close(s.force)
 	s.running = false
 	s.mu.Unlock()
 	s.log.Infof("END server service stop.")
@@ -184,9 +284,17 @@ func (s *Server) infoHandler(w http.ResponseWriter, r *http.Request) {
 	defer s.mu.RUnlock()
 	b, _ := json.Marshal(
 		&struct {
-			Options *Options `json:"options"`
+			Options    *Options         `json:"options"`
+			Schedule   *DeploySchedule  `json:"schedule"`
+			Properties *PropertyRules   `json:"properties"`
+			Signatures *SignaturePolicy `json:"signatures"`
+			Filter     *AppFilter       `json:"filter"`
 		}{
-			Options: s.opts,
+			Options:    s.opts,
+			Schedule:   s.schedule,
+			Properties: s.properties,
+			Signatures: s.signatures,
+			Filter:     s.filter,
 		})
 	w.Write(b)
 }
@@ -203,23 +311,171 @@ func (s *Server) metricsHandler(w http.ResponseWriter, r *http.Request) {
 	runtime.ReadMemStats(mStats)
 	b, _ := json.Marshal(
 		&struct {
-			Options *Options          `json:"options"`
-			Stats   *Status           `json:"stats"`
-			Memory  *runtime.MemStats `json:"memStats"`
+			Options   *Options               `json:"options"`
+			Stats     *Status                `json:"stats"`
+			Deploys   *DeployPoolStatus      `json:"deploys"`
+			Pipelines []*AppPipelineStatus   `json:"pipelines"`
+			Pending   []*PendingDeployStatus `json:"pending"`
+			Blocked   []*BlockedDeployStatus `json:"blocked"`
+			Folders   *FolderCacheStatus     `json:"folderCache"`
+			Payloads  *PayloadCacheStatus    `json:"payloadCache"`
+			Memory    *runtime.MemStats      `json:"memStats"`
+		}{
+			Options:   s.opts,
+			Stats:     s.stats,
+			Deploys:   s.deploys.status(),
+			Pipelines: s.pipelines.status(),
+			Pending:   s.pendingStatus(),
+			Blocked:   s.blockedStatus(),
+			Folders:   s.folders.status(),
+			Payloads:  s.payloads.status(),
+			Memory:    mStats,
+		})
+	w.Write(b)
+}
+
+// targetsHandler handles a client request for the status of the targets managed.
+func (s *Server) targetsHandler(w http.ResponseWriter, r *http.Request) {
+	if s.invalidHeader(w, r) || s.invalidMethod(w, r, httpGet) || s.invalidAuth(w, r) {
+		return
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]*TargetStatus, 0, len(s.monitors))
+	for _, tm := range s.monitors {
+		result = append(result, tm.status())
+	}
+	b, _ := json.Marshal(
+		&struct {
+			Targets []*TargetStatus `json:"targets"`
 		}{
-			Options: s.opts,
-			Stats:   s.stats,
-			Memory:  mStats,
+			Targets: result,
 		})
 	w.Write(b)
 }
 
+// deploysHandler handles a client request for the last deploy of an application in a domain and environment:
+// /v1.0/deploys/{domain}/{environment}/{name}
+func (s *Server) deploysHandler(w http.ResponseWriter, r *http.Request) {
+	if s.invalidHeader(w, r) || s.invalidMethod(w, r, httpGet) || s.invalidAuth(w, r) {
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, httpRouteV1Deploys), "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		http.Error(w, InvalidDeployPath, http.StatusNotFound)
+		return
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	st, err := s.db.QueryDeployByName(parts[0], parts[1], parts[2])
+	switch {
+	case err == sql.ErrNoRows:
+		http.Error(w, DeployNotFound, http.StatusNotFound)
+		return
+	case err != nil:
+		s.log.Errorf("Unable to read deploy from db for %s-%s-%s: %s", parts[0], parts[1], parts[2], err.Error())
+		http.Error(w, DatabaseError, http.StatusInternalServerError)
+		return
+	}
+	b, _ := json.Marshal(st)
+	w.Write(b)
+}
+
 // forceHandler handles a client request to check for new deploys (instead of awaiting timer).
 func (s *Server) forceHandler(w http.ResponseWriter, r *http.Request) {
 	if s.invalidHeader(w, r) || s.invalidMethod(w, r, httpGet) || s.invalidAuth(w, r) {
 		return
 	}
-	s.force <- true // Tell the monitor routine to check for new deploys.
+	// Tell the monitor routine of each target to check for new deploys. A check already waiting is enough.
+	for _, tm := range s.monitors {
+		select {
+		case tm.force <- true:
+		default:
+		}
+	}
+}
+
+// payloadCacheHandler handles a client request to purge the payload cache. The number of payloads purged is
+// returned.
+func (s *Server) payloadCacheHandler(w http.ResponseWriter, r *http.Request) {
+	if s.invalidHeader(w, r) || s.invalidMethod(w, r, httpDelete) || s.invalidAuth(w, r) {
+		return
+	}
+	n := s.payloads.purge()
+	s.log.Infof("Purged %d payloads from the cache", n)
+	b, _ := json.Marshal(&struct {
+		Purged int `json:"purged"`
+	}{n})
+	w.Write(b)
+}
+
+// artWebhookHandler handles an artifactory event notification. If a deploy request file was uploaded to the
+// deploy repo, the monitor checks that application for deploys immediately.
+func (s *Server) artWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if s.invalidMethod(w, r, httpPost) {
+		return
+	}
+	// The route is open to anyone until the signature is checked, so the body read is limited. One byte over
+	// the limit is read to tell a larger event.
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody+1))
+	if len(body) > maxWebhookBody {
+		http.Error(w, WebhookTooLarge, http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, InvalidBody, http.StatusBadRequest)
+		return
+	}
+	if !validWebhookSignature(s.opts.WebhookSecret, body, r.Header.Get(webhookSignatureHeader)) {
+		http.Error(w, InvalidSignature, http.StatusUnauthorized)
+		return
+	}
+	var event ArtWebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		http.Error(w, InvalidJSONText, http.StatusBadRequest)
+		return
+	}
+
+	// Tell the monitor routine of each target using the repo to check this application.
+	scheduled, full := false, false
+	for _, tm := range s.monitors {
+		appName, ok := event.deployRequestApp(tm.target.ArtDeployRepo)
+		if !ok || !s.filter.allows(appName) {
+			continue // Not a deploy request for this target.
+		}
+		select {
+		case tm.check <- appName:
+			scheduled = true
+		default:
+			full = true
+		}
+	}
+	switch {
+	case full:
+		http.Error(w, WebhookQueueFull, http.StatusServiceUnavailable)
+	case scheduled:
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// pendingStatus returns the deploys held by the schedule for every target.
+func (s *Server) pendingStatus() []*PendingDeployStatus {
+	result := make([]*PendingDeployStatus, 0)
+	for _, tm := range s.monitors {
+		result = append(result, tm.pending.status()...)
+	}
+	return result
+}
+
+// blockedStatus returns the versions blocked by the property rules for every target.
+func (s *Server) blockedStatus() []*BlockedDeployStatus {
+	result := make([]*BlockedDeployStatus, 0)
+	for _, tm := range s.monitors {
+		result = append(result, tm.blocked.status()...)
+	}
+	return result
 }
 
 // initResponseHeader sets up the common http response headers for the return of all json calls.
@@ -241,6 +497,15 @@ func (s *Server) incrementStats(r *http.Request) {
 	s.stats.IncrRouteStats(r.URL.Path, r.ContentLength)
 }
 
+// incrementArtErrorStats increments the statistics for an error response from the Artifactory API.
+func (s *Server) incrementArtErrorStats(err error) {
+	if ae, ok := err.(*ArtifactoryError); ok {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.stats.IncrArtErrorStats(ae.StatusCode)
+	}
+}
+
 // invalidHeader validates that the header information is acceptable for processing the
 // request from the client.
 func (s *Server) invalidHeader(w http.ResponseWriter, r *http.Request) bool {
@@ -301,11 +566,15 @@ func (s *Server) LogRequest(r *http.Request) {
 		cl = r.ContentLength
 	}
 
-	bd, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		bd = []byte("Could not parse body")
+	// Webhook events are not logged, as anyone can send them and their size is only limited by the handler.
+	var bd []byte
+	if r.URL.Path != httpRouteV1ArtWebhook {
+		var err error
+		if bd, err = ioutil.ReadAll(r.Body); err != nil {
+			bd = []byte("Could not parse body")
+		}
+		r.Body = ioutil.NopCloser(bytes.NewBuffer(bd)) // We need to set the body back after we read it.
 	}
-	r.Body = ioutil.NopCloser(bytes.NewBuffer(bd)) // We need to set the body back after we read it.
 
 	b, _ := json.Marshal(&requestLogEntry{
 		Method:        r.Method,
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/composer22/coreos-artifactory-monitor/db"
+	"github.com/composer22/coreos-artifactory-monitor/logger"
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+const testAuthToken = "S0M3B3EARERTOK3N"
+
+// testDBSchema is the schema.sql tables in the SQLite dialect.
+var testDBSchema = []string{
+	`CREATE TABLE artifactory_auth_tokens (
+	  id INTEGER PRIMARY KEY AUTOINCREMENT,
+	  token varchar(255) NOT NULL UNIQUE,
+	  created_at datetime NOT NULL,
+	  updated_at datetime NOT NULL,
+	  name varchar(255) DEFAULT NULL,
+	  notes text
+	)`,
+	`CREATE TABLE artifactory_deploys (
+	  id INTEGER PRIMARY KEY AUTOINCREMENT,
+	  deploy_id varchar(255) NOT NULL DEFAULT '',
+	  domain varchar(255) NOT NULL,
+	  environment varchar(255) NOT NULL,
+	  service_name varchar(255) NOT NULL,
+	  version varchar(255) NOT NULL,
+	  status int(11) NOT NULL DEFAULT '1',
+	  checksum varchar(64) NOT NULL DEFAULT '',
+	  message varchar(1024) NOT NULL DEFAULT '',
+	  manifest varchar(2048) NOT NULL DEFAULT '',
+	  units varchar(2048) NOT NULL DEFAULT '',
+	  updated_at datetime NOT NULL,
+	  created_at datetime NOT NULL,
+	  UNIQUE (domain, environment, service_name)
+	)`,
+	`INSERT INTO artifactory_auth_tokens (token, created_at, updated_at) VALUES
+	  ('` + testAuthToken + `', '2015-09-01 10:00:00', '2015-09-01 10:00:00')`,
+}
+
+func init() {
+	// The deploy updates use the MySQL NOW() function.
+	sql.Register("sqlite3_now", &sqlite3.SQLiteDriver{ConnectHook: func(c *sqlite3.SQLiteConn) error {
+		return c.RegisterFunc("NOW", func() string { return time.Now().UTC().Format("2006-01-02 15:04:05") }, false)
+	}})
+}
+
+// newTestDB returns a connection to an in-memory SQLite database with the schema and a valid auth token.
+// The returned sql.DB keeps the database alive and must be closed by the caller.
+func newTestDB(t *testing.T, name string) (*db.DBConnect, *sql.DB) {
+	dsn := "file:" + name + "?mode=memory&cache=shared"
+	raw, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	for _, stmt := range testDBSchema {
+		if _, err := raw.Exec(stmt); err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+	}
+	d, err := db.NewDBConnectDriver("sqlite3_now", dsn)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	return d, raw
+}
+
+// newTestAPIRequest returns a request with the headers required by the API.
+func newTestAPIRequest(method string, path string) *http.Request {
+	req, _ := http.NewRequest(method, path, nil)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+testAuthToken)
+	return req
+}
+
+func TestDeploysHandler(t *testing.T) {
+	d, raw := newTestDB(t, "deploys")
+	defer raw.Close()
+	defer d.Close()
+	if _, err := raw.Exec("INSERT INTO artifactory_deploys (deploy_id, domain, environment, service_name, " +
+		"version, status, updated_at, created_at) VALUES " +
+		"('1d2c', 'example.com', 'development', 'video-mobile', '1.0.1-23', 2, '2015-09-01 10:00:00', " +
+		"'2015-09-01 10:00:00'), " +
+		"('', 'example.com', 'production', 'video-mobile', '1.0.1-21', 3, '2015-09-01 10:00:00', " +
+		"'2015-09-01 10:00:00')"); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	s := New(&Options{}, logger.New(logger.Error, false))
+	s.db = d
+
+	tests := []struct {
+		path    string
+		status  int
+		version string
+	}{
+		{"/v1.0/deploys/example.com/development/video-mobile", http.StatusOK, "1.0.1-23"},
+		{"/v1.0/deploys/example.com/production/video-mobile", http.StatusOK, "1.0.1-21"},
+		{"/v1.0/deploys/example.com/qa/video-mobile", http.StatusNotFound, ""},
+		{"/v1.0/deploys/example.com/development", http.StatusNotFound, ""},
+		{"/v1.0/deploys/example.com/development/video-mobile/extra", http.StatusNotFound, ""},
+	}
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		s.deploysHandler(w, newTestAPIRequest(httpGet, tc.path))
+		if w.Code != tc.status {
+			t.Errorf("%s: expected status %d, received %d.", tc.path, tc.status, w.Code)
+			continue
+		}
+		if tc.status != http.StatusOK {
+			continue
+		}
+		var st db.DeployStatus
+		if err := json.Unmarshal(w.Body.Bytes(), &st); err != nil {
+			t.Errorf("%s: cannot parse response: %s", tc.path, err.Error())
+			continue
+		}
+		if st.Version != tc.version {
+			t.Errorf("%s: expected version %s, received %s.", tc.path, tc.version, st.Version)
+		}
+	}
+
+	// The API token is required.
+	req := newTestAPIRequest(httpGet, "/v1.0/deploys/example.com/development/video-mobile")
+	req.Header.Set("Authorization", "Bearer wrong")
+	w := httptest.NewRecorder()
+	s.deploysHandler(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d without a valid token, received %d.", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestPayloadCacheHandler(t *testing.T) {
+	d, raw := newTestDB(t, "payloadcache")
+	defer raw.Close()
+	defer d.Close()
+	dir, err := ioutil.TempDir("", "payload-cache")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	s := New(&Options{PayloadCacheDir: dir, PayloadCacheSize: 1}, logger.New(logger.Error, false))
+	s.db = d
+	filePath, checksum := newTestCachePayload(t, dir, "a.tar.gz", 100)
+	s.payloads.put(checksum, filePath)
+
+	w := httptest.NewRecorder()
+	s.payloadCacheHandler(w, newTestAPIRequest(httpGet, httpRouteV1PayloadCache))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status %d for a GET, received %d.", http.StatusMethodNotAllowed, w.Code)
+	}
+	req := newTestAPIRequest(httpDelete, httpRouteV1PayloadCache)
+	req.Header.Set("Authorization", "Bearer wrong")
+	w = httptest.NewRecorder()
+	s.payloadCacheHandler(w, req)
+	if w.Code != http.StatusUnauthorized || s.payloads.status().Payloads != 1 {
+		t.Errorf("Expected status %d without a valid token, received %d.", http.StatusUnauthorized, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	s.payloadCacheHandler(w, newTestAPIRequest(httpDelete, httpRouteV1PayloadCache))
+	if w.Code != http.StatusOK || w.Body.String() != `{"purged":1}` {
+		t.Errorf("Unexpected response %d: %s", w.Code, w.Body.String())
+	}
+	if s.payloads.status().Payloads != 0 {
+		t.Errorf("Expected the payload cache to be purged.")
+	}
+}
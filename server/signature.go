@@ -0,0 +1,209 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// SignaturePolicy verifies the detached signatures of the payloads against trusted public keys. It is loaded
+// from the JSON file of --signatures_file. A payload signature is stored next to the payload with a .sig
+// extension, and is required in the environments of the policy.
+type SignaturePolicy struct {
+	Keys    []*TrustedKey `json:"keys"`    // The public keys trusted to sign payloads.
+	Require []string      `json:"require"` // The environments where payloads must be signed. "*" is every environment.
+
+	ed25519Keys map[string]ed25519.PublicKey // The ed25519 keys by name.
+	keyring     openpgp.EntityList           // The GPG keys.
+	gpgNames    map[uint64]string            // The names of the GPG keys by primary key id.
+}
+
+// TrustedKey is a public key trusted to sign payloads. Exactly one of the keys is given.
+type TrustedKey struct {
+	Name    string `json:"name"`    // A name for the key used in logs ex: jenkins.
+	Ed25519 string `json:"ed25519"` // A base64 raw ed25519 public key, or a PEM PUBLIC KEY block.
+	GPG     string `json:"gpg"`     // An ASCII armored GPG public key block.
+}
+
+// loadSignaturePolicy reads and validates a signatures file.
+func loadSignaturePolicy(filePath string) (*SignaturePolicy, error) {
+	b, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read signatures file %s: %s", filePath, err.Error())
+	}
+	sp, err := parseSignaturePolicy(b)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid signatures file %s: %s", filePath, err.Error())
+	}
+	return sp, nil
+}
+
+// parseSignaturePolicy parses the JSON of the signature policy and its keys.
+func parseSignaturePolicy(b []byte) (*SignaturePolicy, error) {
+	sp := &SignaturePolicy{}
+	if err := json.Unmarshal(b, sp); err != nil {
+		return nil, err
+	}
+	if len(sp.Keys) == 0 {
+		return nil, errors.New("At least one trusted key is mandatory.")
+	}
+	sp.ed25519Keys = make(map[string]ed25519.PublicKey)
+	sp.gpgNames = make(map[uint64]string)
+	names := make(map[string]bool)
+	for _, k := range sp.Keys {
+		if k.Name == "" {
+			return nil, errors.New("Every trusted key must have a name.")
+		}
+		if names[k.Name] {
+			return nil, fmt.Errorf("Trusted key %s is duplicated.", k.Name)
+		}
+		names[k.Name] = true
+		switch {
+		case k.Ed25519 != "" && k.GPG == "":
+			pub, err := parseEd25519Key(k.Ed25519)
+			if err != nil {
+				return nil, fmt.Errorf("Trusted key %s: %s", k.Name, err.Error())
+			}
+			sp.ed25519Keys[k.Name] = pub
+		case k.GPG != "" && k.Ed25519 == "":
+			entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(k.GPG))
+			if err != nil {
+				return nil, fmt.Errorf("Trusted key %s: %s", k.Name, err.Error())
+			}
+			for _, e := range entities {
+				sp.gpgNames[e.PrimaryKey.KeyId] = k.Name
+			}
+			sp.keyring = append(sp.keyring, entities...)
+		default:
+			return nil, fmt.Errorf("Trusted key %s must have either an ed25519 or a gpg key.", k.Name)
+		}
+	}
+	return sp, nil
+}
+
+// parseEd25519Key parses a base64 raw ed25519 public key, or a PEM PUBLIC KEY block.
+func parseEd25519Key(s string) (ed25519.PublicKey, error) {
+	if block, _ := pem.Decode([]byte(s)); block != nil {
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, errors.New("PEM key is not an ed25519 key")
+		}
+		return pub, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("ed25519 key must be %d bytes", ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(b), nil
+}
+
+// required returns true if payloads deployed to the environment must be signed. A nil policy requires nothing.
+func (sp *SignaturePolicy) required(env string) bool {
+	if sp == nil {
+		return false
+	}
+	for _, e := range sp.Require {
+		if e == env || e == signatureAnyEnvironment {
+			return true
+		}
+	}
+	return false
+}
+
+// verify checks a detached signature of the payload file against the trusted keys and returns the name of
+// the key that made it. An ed25519 signature, raw or base64, is of the SHA-256 digest of the payload given
+// by its hex checksum. A GPG signature, binary or armored, is of the payload.
+func (sp *SignaturePolicy) verify(filePath string, checksum string, sig []byte) (string, error) {
+	if edSig, ok := ed25519Signature(sig); ok {
+		digest, err := hex.DecodeString(checksum)
+		if err != nil {
+			return "", err
+		}
+		for name, pub := range sp.ed25519Keys {
+			if ed25519.Verify(pub, digest, edSig) {
+				return name, nil
+			}
+		}
+		return "", errors.New("ed25519 signature is not from a trusted key")
+	}
+
+	if len(sp.keyring) == 0 {
+		return "", errors.New("GPG signature but no trusted GPG keys")
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	var signer *openpgp.Entity
+	if bytes.HasPrefix(bytes.TrimSpace(sig), []byte("-----BEGIN PGP SIGNATURE")) {
+		signer, err = openpgp.CheckArmoredDetachedSignature(sp.keyring, f, bytes.NewReader(sig), nil)
+	} else {
+		signer, err = openpgp.CheckDetachedSignature(sp.keyring, f, bytes.NewReader(sig), nil)
+	}
+	if err != nil {
+		return "", fmt.Errorf("GPG signature: %s", err.Error())
+	}
+	return sp.gpgNames[signer.PrimaryKey.KeyId], nil
+}
+
+// ed25519Signature returns the signature if it is an ed25519 signature, raw or base64.
+func ed25519Signature(sig []byte) ([]byte, bool) {
+	if len(sig) == ed25519.SignatureSize {
+		return sig, true
+	}
+	b, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sig)))
+	if err == nil && len(b) == ed25519.SignatureSize {
+		return b, true
+	}
+	return nil, false
+}
+
+// getArtPayloadSignature retrieves the detached signature of a payload from its url. Nil is returned if the
+// payload has no signature. A signature larger than maxSignatureSize is an error.
+func (s *Server) getArtPayloadSignature(url string) ([]byte, error) {
+	req, err := s.newArtRequest(httpGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSignatureSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if err := artResponseError(resp, body); err != nil {
+		s.incrementArtErrorStats(err)
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if len(body) > maxSignatureSize {
+		return nil, fmt.Errorf("Signature larger than %d bytes", maxSignatureSize)
+	}
+	return body, nil
+}
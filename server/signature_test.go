@@ -0,0 +1,218 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+)
+
+// newTestGPGKey returns a GPG entity and its armored public key.
+func newTestGPGKey(t *testing.T, name string) (*openpgp.Entity, string) {
+	e, err := openpgp.NewEntity(name, "", name+"@example.com", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	e.Serialize(w)
+	w.Close()
+	return e, buf.String()
+}
+
+// newTestSignaturePolicy returns a policy trusting an ed25519 and a GPG key, with their private keys.
+func newTestSignaturePolicy(t *testing.T, require ...string) (*SignaturePolicy, ed25519.PrivateKey, *openpgp.Entity) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	gpg, armored := newTestGPGKey(t, "release")
+	b, _ := json.Marshal(map[string]interface{}{
+		"keys": []*TrustedKey{
+			{Name: "jenkins", Ed25519: base64.StdEncoding.EncodeToString(pub)},
+			{Name: "release", GPG: armored},
+		},
+		"require": require,
+	})
+	sp, err := parseSignaturePolicy(b)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	return sp, priv, gpg
+}
+
+func TestParseSignaturePolicy(t *testing.T) {
+	pub, _, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKIXPublicKey(pub)
+	pemKey := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	rawKey := base64.StdEncoding.EncodeToString(pub)
+	tests := []struct {
+		name string
+		json string
+		ok   bool
+	}{
+		{"base64 key", `{"keys": [{"name": "ci", "ed25519": "` + rawKey + `"}], "require": ["production"]}`, true},
+		{"PEM key", `{"keys": [{"name": "ci", "ed25519": ` + jsonString(pemKey) + `}]}`, true},
+		{"no keys", `{"require": ["production"]}`, false},
+		{"no name", `{"keys": [{"ed25519": "` + rawKey + `"}]}`, false},
+		{"duplicate", `{"keys": [{"name": "ci", "ed25519": "` + rawKey + `"}, {"name": "ci", "ed25519": "` +
+			rawKey + `"}]}`, false},
+		{"both keys", `{"keys": [{"name": "ci", "ed25519": "` + rawKey + `", "gpg": "x"}]}`, false},
+		{"no key", `{"keys": [{"name": "ci"}]}`, false},
+		{"short key", `{"keys": [{"name": "ci", "ed25519": "AAAA"}]}`, false},
+		{"bad gpg", `{"keys": [{"name": "ci", "gpg": "not armored"}]}`, false},
+		{"bad json", `{"keys": [`, false},
+	}
+	for _, tc := range tests {
+		if _, err := parseSignaturePolicy([]byte(tc.json)); (err == nil) != tc.ok {
+			t.Errorf("%s: expected success %t, received %v", tc.name, tc.ok, err)
+		}
+	}
+
+	sp, _, _ := newTestSignaturePolicy(t, "production")
+	if !sp.required("production") || sp.required("development") {
+		t.Errorf("Expected signatures required in production only.")
+	}
+	if sp, _, _ := newTestSignaturePolicy(t, "*"); !sp.required("development") {
+		t.Errorf("Expected signatures required in every environment.")
+	}
+	if (*SignaturePolicy)(nil).required("production") {
+		t.Errorf("Expected no signatures required without a policy.")
+	}
+}
+
+// jsonString returns s as a JSON string.
+func jsonString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+func TestSignaturePolicyVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signature")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	filePath := filepath.Join(dir, "payload.tar.gz")
+	payload := []byte("payload")
+	ioutil.WriteFile(filePath, payload, 0644)
+	sum := sha256.Sum256(payload)
+	checksum := hex.EncodeToString(sum[:])
+
+	sp, priv, gpg := newTestSignaturePolicy(t)
+	_, untrustedPriv, _ := ed25519.GenerateKey(rand.Reader)
+	untrustedGPG, _ := newTestGPGKey(t, "untrusted")
+	edSig := ed25519.Sign(priv, sum[:])
+	var gpgSig, gpgArmored, untrustedSig bytes.Buffer
+	openpgp.DetachSign(&gpgSig, gpg, bytes.NewReader(payload), nil)
+	openpgp.ArmoredDetachSign(&gpgArmored, gpg, bytes.NewReader(payload), nil)
+	openpgp.DetachSign(&untrustedSig, untrustedGPG, bytes.NewReader(payload), nil)
+	otherSum := sha256.Sum256([]byte("other"))
+
+	tests := []struct {
+		name     string
+		sig      []byte
+		checksum string
+		signer   string
+	}{
+		{"ed25519 raw", edSig, checksum, "jenkins"},
+		{"ed25519 base64", []byte(base64.StdEncoding.EncodeToString(edSig) + "\n"), checksum, "jenkins"},
+		{"ed25519 other payload", edSig, hex.EncodeToString(otherSum[:]), ""},
+		{"ed25519 untrusted", ed25519.Sign(untrustedPriv, sum[:]), checksum, ""},
+		{"gpg binary", gpgSig.Bytes(), checksum, "release"},
+		{"gpg armored", gpgArmored.Bytes(), checksum, "release"},
+		{"gpg untrusted", untrustedSig.Bytes(), checksum, ""},
+		{"garbage", []byte("not a signature"), checksum, ""},
+	}
+	for _, tc := range tests {
+		signer, err := sp.verify(filePath, tc.checksum, tc.sig)
+		if signer != tc.signer || (err == nil) != (tc.signer != "") {
+			t.Errorf("%s: expected signer %q, received %q (%v)", tc.name, tc.signer, signer, err)
+		}
+	}
+
+	// A GPG signature of another file does not verify.
+	ioutil.WriteFile(filePath, []byte("tampered"), 0644)
+	if _, err := sp.verify(filePath, checksum, gpgSig.Bytes()); err == nil {
+		t.Errorf("Expected a GPG signature of a tampered payload to be refused.")
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signature")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	const tarFileName = "example.com-production-video-mobile-1.0.1-22.tar.gz"
+	filePath := filepath.Join(dir, tarFileName)
+	payload := []byte("payload")
+	ioutil.WriteFile(filePath, payload, 0644)
+	sum := sha256.Sum256(payload)
+	checksum := hex.EncodeToString(sum[:])
+
+	sp, priv, _ := newTestSignaturePolicy(t, "production")
+	sigs := make(map[string][]byte)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sig, ok := sigs[r.URL.Path]
+		if !ok {
+			http.Error(w, `{"errors": [{"status": 404, "message": "Not Found"}]}`, http.StatusNotFound)
+			return
+		}
+		w.Write(sig)
+	}))
+	defer ts.Close()
+	sigPath := "/payloads/video-mobile/" + tarFileName + signatureExt
+
+	tests := []struct {
+		name     string
+		env      string
+		sig      []byte
+		ok       bool
+		signedBy string
+	}{
+		{"signed", "production", ed25519.Sign(priv, sum[:]), true, "jenkins"},
+		{"unsigned where required", "production", nil, false, ""},
+		{"unsigned where not required", "development", nil, true, ""},
+		{"invalid where not required", "development", ed25519.Sign(priv, []byte("other")), false, ""},
+		{"too large", "development", bytes.Repeat([]byte("x"), maxSignatureSize+1), false, ""},
+	}
+	for _, tc := range tests {
+		s := newTestServer(ts.URL, DiscoveryAQL)
+		s.signatures = sp
+		target := s.monitors[0].target
+		target.Environment = tc.env
+		target.ArtPayloadRepo = "payloads"
+		delete(sigs, sigPath)
+		if tc.sig != nil {
+			sigs[sigPath] = tc.sig
+		}
+		d := NewDeployWorker(target, "video-mobile", "1.0.1-22", s)
+		errMsg := d.verifySignature(filePath, tarFileName, checksum)
+		if (errMsg == "") != tc.ok || d.SignedBy != tc.signedBy {
+			t.Errorf("%s: expected success %t signed by %q, received %q signed by %q", tc.name, tc.ok,
+				tc.signedBy, errMsg, d.SignedBy)
+		}
+	}
+
+	// Without a policy no signature is requested.
+	s := newTestServer("http://127.0.0.1:1", DiscoveryAQL)
+	d := NewDeployWorker(s.monitors[0].target, "video-mobile", "1.0.1-22", s)
+	if errMsg := d.verifySignature(filePath, tarFileName, checksum); errMsg != "" {
+		t.Errorf("Expected no signature verified without a policy: %s", errMsg)
+	}
+}
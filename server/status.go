@@ -2,23 +2,27 @@ package server
 
 import (
 	"encoding/json"
+	"strconv"
 	"time"
 )
 
 // Status contains runtime statistics.
 type Status struct {
-	Start        time.Time                   `json:"startTime"`    // The start time of the server.
-	RequestCount int64                       `json:"requestCount"` // How many requests came in to the server.
-	RequestBytes int64                       `json:"requestBytes"` // Size of the requests in bytes.
-	RouteStats   map[string]map[string]int64 `json:"routeStats"`   // How many requests/bytes came into each route.
+	Start         time.Time                   `json:"startTime"`     // The start time of the server.
+	RequestCount  int64                       `json:"requestCount"`  // How many requests came in to the server.
+	RequestBytes  int64                       `json:"requestBytes"`  // Size of the requests in bytes.
+	RouteStats    map[string]map[string]int64 `json:"routeStats"`    // How many requests/bytes came into each route.
+	ArtErrors     int64                       `json:"artErrors"`     // How many error responses came from artifactory.
+	ArtErrorStats map[string]int64            `json:"artErrorStats"` // How many error responses by status code.
 }
 
 // NewStatus is a factory function that returns a new instance of Status.
 // options is an optional list of functions that initialize the structure
 func NewStatus(options ...func(*Status)) *Status {
 	st := &Status{
-		Start:      time.Now(),
-		RouteStats: make(map[string]map[string]int64),
+		Start:         time.Now(),
+		RouteStats:    make(map[string]map[string]int64),
+		ArtErrorStats: make(map[string]int64),
 	}
 
 	for _, f := range options {
@@ -47,6 +51,12 @@ func (s *Status) IncrRouteStats(path string, rb int64) {
 	}
 }
 
+// IncrArtErrorStats increments the stats totals for an error response from artifactory.
+func (s *Status) IncrArtErrorStats(statusCode int) {
+	s.ArtErrors++
+	s.ArtErrorStats[strconv.Itoa(statusCode)]++
+}
+
 // String is an implentation of the Stringer interface so the structure is returned as a
 // string to fmt.Print() etc.
 func (s *Status) String() string {
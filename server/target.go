@@ -0,0 +1,233 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Target is a domain and environment managed by the monitor, with the repos and the coreos-deploy service
+// used for its deploys.
+type Target struct {
+	Domain         string            `json:"domain"`         // The domain of the target.
+	Environment    string            `json:"environment"`    // The environment of the target (dev, stage, prod, etc).
+	DeployURL      string            `json:"deployURL"`      // The coreos-deploy url endpoint.
+	DeployToken    string            `json:"deployToken"`    // The coreos-deploy token for security access.
+	DeployClusters map[string]string `json:"deployClusters"` // The coreos-deploy url endpoints by cluster.
+	ArtDeployRepo  string            `json:"artDeployRepo"`  // The artifactory repo of the deploy request files.
+	ArtPayloadRepo string            `json:"artPayloadRepo"` // The artifactory repo of the deployment payloads.
+}
+
+// optionsTarget returns the target configured by the command line options.
+func optionsTarget(o *Options) *Target {
+	t := &Target{
+		Domain:         o.Domain,
+		Environment:    o.Environment,
+		DeployURL:      o.DeployURL,
+		DeployToken:    o.DeployToken,
+		DeployClusters: o.DeployClusters,
+		ArtDeployRepo:  o.ArtDeployRepo,
+		ArtPayloadRepo: o.ArtPayloadRepo,
+	}
+	t.clean()
+	return t
+}
+
+// loadTargets reads the targets of a JSON targets file. Fields missing from a target are taken from
+// the command line options.
+func loadTargets(filePath string, o *Options) ([]*Target, error) {
+	b, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read targets file %s: %s", filePath, err.Error())
+	}
+	targets, err := parseTargets(b, o)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid targets file %s: %s", filePath, err.Error())
+	}
+	return targets, nil
+}
+
+// parseTargets parses and validates the JSON of a targets file.
+func parseTargets(b []byte, o *Options) ([]*Target, error) {
+	file := struct {
+		Targets []*Target `json:"targets"`
+	}{}
+	if err := json.Unmarshal(b, &file); err != nil {
+		return nil, err
+	}
+	if len(file.Targets) == 0 {
+		return nil, errors.New("At least one target is mandatory.")
+	}
+	defaults := optionsTarget(o)
+	seen := make(map[string]bool)
+	for _, t := range file.Targets {
+		t.inherit(defaults)
+		t.clean()
+		if err := t.validate(); err != nil {
+			return nil, err
+		}
+		if seen[t.key()] {
+			return nil, fmt.Errorf("Target %s is listed more than once.", t.key())
+		}
+		seen[t.key()] = true
+	}
+	return file.Targets, nil
+}
+
+// inherit sets the fields missing from the target to those of the defaults.
+func (t *Target) inherit(defaults *Target) {
+	if t.Domain == "" {
+		t.Domain = defaults.Domain
+	}
+	if t.Environment == "" {
+		t.Environment = defaults.Environment
+	}
+	if t.DeployURL == "" {
+		t.DeployURL = defaults.DeployURL
+	}
+	if t.DeployToken == "" {
+		t.DeployToken = defaults.DeployToken
+	}
+	if t.DeployClusters == nil {
+		t.DeployClusters = defaults.DeployClusters
+	}
+	if t.ArtDeployRepo == "" {
+		t.ArtDeployRepo = defaults.ArtDeployRepo
+	}
+	if t.ArtPayloadRepo == "" {
+		t.ArtPayloadRepo = defaults.ArtPayloadRepo
+	}
+}
+
+// clean trims the coreos-deploy url endpoints.
+func (t *Target) clean() {
+	t.DeployURL = strings.TrimRight(strings.TrimSpace(t.DeployURL), "/")
+	for cluster, url := range t.DeployClusters {
+		t.DeployClusters[cluster] = strings.TrimRight(strings.TrimSpace(url), "/")
+	}
+}
+
+// validate checks the mandatory fields of a target.
+func (t *Target) validate() error {
+	switch {
+	case t.Domain == "":
+		return errors.New("Target domain is mandatory.")
+	case t.Environment == "":
+		return fmt.Errorf("Target %s environment is mandatory.", t.Domain)
+	case t.DeployURL == "":
+		return fmt.Errorf("Target %s deploy URL is mandatory.", t.key())
+	case t.DeployToken == "":
+		return fmt.Errorf("Target %s deploy authorization token is mandatory.", t.key())
+	case t.ArtDeployRepo == "":
+		return fmt.Errorf("Target %s deploy request repo is mandatory.", t.key())
+	case t.ArtPayloadRepo == "":
+		return fmt.Errorf("Target %s payload repo is mandatory.", t.key())
+	}
+	for cluster, url := range t.DeployClusters {
+		if url == "" {
+			return fmt.Errorf("Target %s deploy URL for cluster %s is mandatory.", t.key(), cluster)
+		}
+	}
+	return nil
+}
+
+// key returns the unique name of the target ex: example.com/development
+func (t *Target) key() string {
+	return fmt.Sprintf("%s/%s", t.Domain, t.Environment)
+}
+
+// deployURL returns the coreos-deploy url endpoint of a cluster. The default endpoint is returned
+// if no cluster is given.
+func (t *Target) deployURL(cluster string) (string, bool) {
+	if cluster == "" {
+		return t.DeployURL, true
+	}
+	url, ok := t.DeployClusters[cluster]
+	return url, ok
+}
+
+// targetMonitor holds the state of the monitor of one target. Each target is polled by its own go routine.
+type targetMonitor struct {
+	mu          sync.Mutex
+	target      *Target         // The target monitored.
+	force       chan bool       // A channel to signal to the monitor to look for deploys.
+	check       chan string     // A channel to signal to the monitor to look for deploys of one application.
+	pending     *pendingDeploys // Deploy jobs held by the schedule.
+	blocked     *blockedDeploys // Versions blocked by the property rules.
+	lastPoll    time.Time       // When the last check for deploys was made.
+	lastSuccess time.Time       // When the last check for deploys succeeded.
+	lastError   string          // Why the last check for deploys failed, if it did.
+	polls       int64           // How many checks for deploys were made.
+	pollErrors  int64           // How many checks for deploys failed.
+	submitted   int64           // How many deploy jobs were queued.
+}
+
+// TargetStatus describes a target and the state of its monitor.
+type TargetStatus struct {
+	Domain         string                 `json:"domain"`         // The domain of the target.
+	Environment    string                 `json:"environment"`    // The environment of the target.
+	DeployURL      string                 `json:"deployURL"`      // The coreos-deploy url endpoint.
+	ArtDeployRepo  string                 `json:"artDeployRepo"`  // The artifactory repo of the deploy request files.
+	ArtPayloadRepo string                 `json:"artPayloadRepo"` // The artifactory repo of the deployment payloads.
+	LastPoll       time.Time              `json:"lastPoll"`       // When the last check for deploys was made.
+	LastSuccess    time.Time              `json:"lastSuccess"`    // When the last check for deploys succeeded.
+	LastError      string                 `json:"lastError"`      // Why the last check failed, if it did.
+	Polls          int64                  `json:"polls"`          // How many checks for deploys were made.
+	PollErrors     int64                  `json:"pollErrors"`     // How many checks for deploys failed.
+	Submitted      int64                  `json:"submitted"`      // How many deploy jobs were queued.
+	Pending        []*PendingDeployStatus `json:"pending"`        // The deploys held by the schedule.
+	Blocked        []*BlockedDeployStatus `json:"blocked"`        // The versions blocked by the property rules.
+}
+
+// newTargetMonitor is a factory function that returns the monitor state of a target.
+func newTargetMonitor(t *Target) *targetMonitor {
+	return &targetMonitor{
+		target:  t,
+		force:   make(chan bool, 1),
+		check:   make(chan string, webhookQueueSize),
+		pending: newPendingDeploys(),
+		blocked: newBlockedDeploys(),
+	}
+}
+
+// polled records the result of a check for deploys.
+func (tm *targetMonitor) polled(err error, submitted int) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.lastPoll = time.Now()
+	tm.polls++
+	tm.submitted += int64(submitted)
+	if err != nil {
+		tm.lastError = err.Error()
+		tm.pollErrors++
+		return
+	}
+	tm.lastSuccess = tm.lastPoll
+	tm.lastError = ""
+}
+
+// status returns a snapshot of the target and its monitor.
+func (tm *targetMonitor) status() *TargetStatus {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	t := tm.target
+	return &TargetStatus{
+		Domain:         t.Domain,
+		Environment:    t.Environment,
+		DeployURL:      t.DeployURL,
+		ArtDeployRepo:  t.ArtDeployRepo,
+		ArtPayloadRepo: t.ArtPayloadRepo,
+		LastPoll:       tm.lastPoll,
+		LastSuccess:    tm.lastSuccess,
+		LastError:      tm.lastError,
+		Polls:          tm.polls,
+		PollErrors:     tm.pollErrors,
+		Submitted:      tm.submitted,
+		Pending:        tm.pending.status(),
+		Blocked:        tm.blocked.status(),
+	}
+}
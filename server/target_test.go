@@ -0,0 +1,99 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/composer22/coreos-artifactory-monitor/logger"
+)
+
+func TestParseTargets(t *testing.T) {
+	opts := &Options{
+		Domain:         "example.com",
+		Environment:    DefaultEnvironment,
+		DeployToken:    "D3Pl0YT0Ken",
+		ArtPayloadRepo: "cluster-payloads",
+	}
+	targets, err := parseTargets([]byte(`{"targets": [
+		{"environment": "development", "deployURL": "http://dev-coreos.example.com/",
+		 "artDeployRepo": "cluster-deploys-dev"},
+		{"environment": "production", "deployURL": "http://coreos.example.com", "deployToken": "Pr0DT0K3n",
+		 "artDeployRepo": "cluster-deploys-prod", "artPayloadRepo": "cluster-payloads-prod"}
+	]}`), opts)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if len(targets) != 2 {
+		t.Fatalf("Expected 2 targets, received %d.", len(targets))
+	}
+	dev, prod := targets[0], targets[1]
+	if dev.key() != "example.com/development" || dev.DeployURL != "http://dev-coreos.example.com" ||
+		dev.DeployToken != "D3Pl0YT0Ken" || dev.ArtPayloadRepo != "cluster-payloads" {
+		t.Errorf("Unexpected development target: %+v", dev)
+	}
+	if prod.key() != "example.com/production" || prod.DeployToken != "Pr0DT0K3n" ||
+		prod.ArtPayloadRepo != "cluster-payloads-prod" {
+		t.Errorf("Unexpected production target: %+v", prod)
+	}
+
+	for _, content := range []string{
+		`{"targets": []}`,
+		`{"targets": [{"environment": "qa", "deployURL": "http://qa"}]}`, // No deploy repo.
+		`{"targets": [{"environment": "qa", "deployURL": "http://qa", "artDeployRepo": "a"},
+			{"environment": "qa", "deployURL": "http://qa2", "artDeployRepo": "b"}]}`, // Listed twice.
+		`{"targets": {}}`,
+	} {
+		if _, err := parseTargets([]byte(content), opts); err == nil {
+			t.Errorf("Expected an error parsing %s.", content)
+		}
+	}
+}
+
+func TestArtWebhookHandlerTargets(t *testing.T) {
+	s := New(&Options{WebhookSecret: testWebhookSecret}, logger.New(logger.Error, false))
+	s.monitors = []*targetMonitor{
+		newTargetMonitor(&Target{Domain: "example.com", Environment: "development", ArtDeployRepo: "deploys-dev"}),
+		newTargetMonitor(&Target{Domain: "example.com", Environment: "qa", ArtDeployRepo: "deploys-qa"}),
+		newTargetMonitor(&Target{Domain: "example.org", Environment: "qa", ArtDeployRepo: "deploys-qa"}),
+	}
+	body := []byte(`{"domain":"artifact","event_type":"deployed","data":{"repo_key":"deploys-qa",` +
+		`"path":"video-mobile/1.0.1-23.deploy","name":"1.0.1-23.deploy"}}`)
+	req, _ := http.NewRequest(httpPost, httpRouteV1ArtWebhook, bytes.NewReader(body))
+	req.Header.Set(webhookSignatureHeader, signWebhook(testWebhookSecret, body))
+	w := httptest.NewRecorder()
+	s.artWebhookHandler(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Errorf("Expected status %d, received %d.", http.StatusAccepted, w.Code)
+	}
+	for i, expected := range []int{0, 1, 1} {
+		if len(s.monitors[i].check) != expected {
+			t.Errorf("Target %s: expected %d checks, received %d.", s.monitors[i].target.key(), expected,
+				len(s.monitors[i].check))
+		}
+	}
+}
+
+func TestDeployPipelinesTargets(t *testing.T) {
+	pool := newDeployPool(0)
+	release := make(chan bool)
+	pool.run = func(d *DeployWorker) { <-release }
+	p := newDeployPipelines(pool, logger.New(logger.Error, false))
+	dev := &Target{Domain: "example.com", Environment: "development"}
+	qa := &Target{Domain: "example.com", Environment: "qa"}
+
+	// The same application of two targets deploys in two pipelines.
+	p.Submit(&DeployWorker{Target: dev, Name: "video-mobile", Version: "1.0.1-1"})
+	p.Submit(&DeployWorker{Target: qa, Name: "video-mobile", Version: "1.0.1-1"})
+	st := p.status()
+	if len(st) != 2 || st[0].Environment != "development" || st[1].Environment != "qa" ||
+		st[0].Active == nil || st[1].Active == nil {
+		t.Errorf("Expected a pipeline per target, received %+v", st)
+	}
+	if ps := pool.status(); ps.Running != 2 {
+		t.Errorf("Expected 2 running jobs, received %d.", ps.Running)
+	}
+	close(release)
+	pool.Wait()
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// tlsVersions maps the minimum TLS version names to their values.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// validateTLS checks the TLS options.
+func (o *Options) validateTLS() error {
+	if (o.TLSCertFile == "") != (o.TLSKeyFile == "") {
+		return errors.New("TLS client certificate and key must be given together.")
+	}
+	if _, ok := tlsVersions[o.TLSMinVersion]; o.TLSMinVersion != "" && !ok {
+		return fmt.Errorf("TLS minimum version %s is invalid.", o.TLSMinVersion)
+	}
+	return nil
+}
+
+// newTLSConfig returns the TLS configuration of outbound connections from the options, or nil if no TLS
+// options are given. The CA bundle is added to the system roots.
+func newTLSConfig(o *Options) (*tls.Config, error) {
+	if o.TLSCAFile == "" && o.TLSCertFile == "" && o.TLSMinVersion == "" {
+		return nil, nil
+	}
+	cfg := &tls.Config{}
+	if o.TLSCAFile != "" {
+		pem, err := ioutil.ReadFile(o.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read TLS CA file %s: %s", o.TLSCAFile, err.Error())
+		}
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in TLS CA file %s.", o.TLSCAFile)
+		}
+		cfg.RootCAs = roots
+	}
+	if o.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.TLSCertFile, o.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot load TLS client certificate %s: %s", o.TLSCertFile, err.Error())
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if o.TLSMinVersion != "" {
+		cfg.MinVersion = tlsVersions[o.TLSMinVersion]
+	}
+	return cfg, nil
+}
+
+// setDefaultTransportTLS applies the TLS options to the default transport. It is shared by our clients to
+// artifactory, including payload downloads. The coreos-deploy client library has no option for a transport or
+// TLS configuration, so the options only reach coreos-deploy because its requests use the default transport.
+// TestDeployClientTLS checks this assumption.
+func setDefaultTransportTLS(o *Options) error {
+	cfg, err := newTLSConfig(o)
+	if err != nil || cfg == nil {
+		return err
+	}
+	t, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return errors.New("Cannot apply the TLS options to the default transport.")
+	}
+	t.TLSClientConfig = cfg
+	t.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	coscl "github.com/composer22/coreos-deploy-client/client"
+)
+
+// writeTestPEM writes a PEM block to a file in the directory and returns its path.
+func writeTestPEM(t *testing.T, dir string, name string, blockType string, der []byte) string {
+	filePath := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(filePath, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	return filePath
+}
+
+// newTestClientCert writes a self-signed client certificate and its key to the directory. The parsed
+// certificate and the file paths are returned.
+func newTestClientCert(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "coreos-artifactory-monitor"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	return cert, writeTestPEM(t, dir, "client.crt", "CERTIFICATE", der),
+		writeTestPEM(t, dir, "client.key", "EC PRIVATE KEY", keyDER)
+}
+
+// tlsRequest makes a GET request through a transport using the TLS options.
+func tlsRequest(o *Options, url string) error {
+	cfg, err := newTLSConfig(o)
+	if err != nil {
+		return err
+	}
+	cl := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}, Timeout: 5 * time.Second}
+	resp, err := cl.Get(url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func TestTLSOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	caFile := writeTestPEM(t, dir, "ca.crt", "CERTIFICATE", ts.Certificate().Raw)
+
+	// The server requiring mutual TLS only trusts the client certificate.
+	clientCert, certFile, keyFile := newTestClientCert(t, dir)
+	mts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	mts.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: x509.NewCertPool(),
+		MaxVersion: tls.VersionTLS12}
+	mts.TLS.ClientCAs.AddCert(clientCert)
+	mts.StartTLS()
+	defer mts.Close()
+
+	tests := []struct {
+		name string
+		opts *Options
+		url  string
+		ok   bool
+	}{
+		{"no CA", &Options{}, ts.URL, false},
+		{"CA", &Options{TLSCAFile: caFile}, ts.URL, true},
+		{"mutual TLS", &Options{TLSCAFile: caFile, TLSCertFile: certFile, TLSKeyFile: keyFile}, mts.URL, true},
+		{"no client certificate", &Options{TLSCAFile: caFile}, mts.URL, false},
+		{"minimum version", &Options{TLSCAFile: caFile, TLSCertFile: certFile, TLSKeyFile: keyFile,
+			TLSMinVersion: "1.3"}, mts.URL, false},
+	}
+	for _, tc := range tests {
+		if err := tlsRequest(tc.opts, tc.url); (err == nil) != tc.ok {
+			t.Errorf("%s: expected success %t, received %v", tc.name, tc.ok, err)
+		}
+	}
+
+	if cfg, err := newTLSConfig(&Options{}); cfg != nil || err != nil {
+		t.Errorf("Expected no TLS configuration without TLS options.")
+	}
+	for _, o := range []*Options{
+		{TLSCAFile: filepath.Join(dir, "missing.crt")},
+		{TLSCAFile: keyFile},
+		{TLSCertFile: caFile, TLSKeyFile: keyFile},
+	} {
+		if _, err := newTLSConfig(o); err == nil {
+			t.Errorf("Expected an error loading %+v", o)
+		}
+	}
+	for _, o := range []*Options{{TLSCertFile: certFile}, {TLSKeyFile: keyFile}, {TLSMinVersion: "1.4"}} {
+		if err := o.validateTLS(); err == nil {
+			t.Errorf("Expected an error validating %+v", o)
+		}
+	}
+}
+
+func TestSetDefaultTransportTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	fake, _ := newFakeArtifactory(testDeployFiles)
+	ts := httptest.NewTLSServer(fake)
+	defer ts.Close()
+	caFile := writeTestPEM(t, dir, "ca.crt", "CERTIFICATE", ts.Certificate().Raw)
+
+	dt := http.DefaultTransport.(*http.Transport)
+	saved := dt.TLSClientConfig
+	defer func() { dt.TLSClientConfig = saved }()
+
+	// The artifactory requests and readiness checks of the server use the default transport.
+	s := newTestServer(ts.URL, DiscoveryAQL)
+	if _, err := s.getDeployVersions(s.monitors[0].target, ""); err == nil {
+		t.Errorf("Expected an unknown authority error before the TLS options are applied.")
+	}
+	s.opts.TLSCAFile = caFile
+	if err := setDefaultTransportTLS(s.opts); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if deploys, err := s.getDeployVersions(s.monitors[0].target, ""); err != nil || len(deploys) != 2 {
+		t.Errorf("Expected the deploy versions over TLS, received %v (%v)", deploys, err)
+	}
+	if hc := s.checkArtifactory(); !hc.Healthy {
+		t.Errorf("Expected artifactory to be healthy over TLS: %s", hc.Message)
+	}
+}
+
+// TestDeployClientTLS checks the assumption that the coreos-deploy client library uses the default transport,
+// so the TLS options reach the coreos-deploy services.
+func TestDeployClientTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	clientCert, certFile, keyFile := newTestClientCert(t, dir)
+	deploy := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"deployID":"1d2c"}`))
+	}))
+	deploy.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: x509.NewCertPool()}
+	deploy.TLS.ClientCAs.AddCert(clientCert)
+	deploy.StartTLS()
+	defer deploy.Close()
+	caFile := writeTestPEM(t, dir, "ca.crt", "CERTIFICATE", deploy.Certificate().Raw)
+	templateFile := filepath.Join(dir, "app@.service.tmpl")
+	ioutil.WriteFile(templateFile, []byte("[Unit]"), 0644)
+
+	dt := http.DefaultTransport.(*http.Transport)
+	saved := dt.TLSClientConfig
+	defer func() { dt.TLSClientConfig = saved }()
+
+	s := newTestServer("http://127.0.0.1:1", DiscoveryAQL)
+	d := NewDeployWorker(s.monitors[0].target, "app", "1.0.1-22", s)
+	submit := func() (string, string) {
+		return d.submitDeployRequest(coscl.New(&coscl.Options{Name: "app", Version: "1.0.1-22",
+			ImageVersion: "1.0.1-22", NumInstances: 1, TemplateFilePath: templateFile, Url: deploy.URL}))
+	}
+	if _, errMsg := submit(); errMsg == "" {
+		t.Errorf("Expected the deploy request to fail before the TLS options are applied.")
+	}
+	s.opts.TLSCAFile, s.opts.TLSCertFile, s.opts.TLSKeyFile = caFile, certFile, keyFile
+	if err := setDefaultTransportTLS(s.opts); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if deployID, errMsg := submit(); errMsg != "" || deployID != "1d2c" {
+		t.Errorf("Expected the deploy request over mutual TLS, received %q (%s)", deployID, errMsg)
+	}
+}
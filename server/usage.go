@@ -9,6 +9,7 @@ const usageText = `
 Description: coreos-artifactory-monitor is a server for monitoring deploy needs from Artifactory to a coreos cluster.
 
 Usage: coreos-artifactory-monitor [options...]
+       coreos-artifactory-monitor validate-payload FILE.tar.gz...
 
 Server options:
     -N, --name NAME                  NAME of the server (default: empty field).
@@ -17,12 +18,55 @@ Server options:
     -E, --environment ENVIRONMENT    ENVIRONMENT (development, qa, staging, production).
     -s, --deploy_url URL             URL to the coreos-deploy service.
     -k, --deploy_token TOKEN         Security TOKEN to access the coreos-deploy service.
+        --deploy_clusters LIST       LIST of cluster=URL pairs of coreos-deploy services that a deploy manifest
+                                     can target by cluster name (default: only --deploy_url).
     -a, --art_endpoint APIURL        The base APIURL to the artifactory API service.
     -u, --art_userid USERID          USERID to login to the artifactory API service.
     -w, --art_password PASSWORD      PASSWORD to login to the artifactory API service.
+        --art_auth MODE              How to authenticate to the artifactory API service: basic (userid and
+                                     password), apikey or token (default: basic).
+        --art_apikey KEY             API KEY sent in the X-JFrog-Art-Api header when --art_auth apikey.
+        --art_token TOKEN            Bearer access TOKEN when --art_auth token.
+        --art_refresh_token TOKEN    Refresh TOKEN used to renew the access token before it expires.
     -g, --art_polling INTERVAL       How often to check artifactory for deploys in INTERVAL seconds (default: 300 sec).
     -t, --art_deploy_repo REPO       The name of the REPO where the deploy request files are stored.
     -y, --art_payload_repo REPO      The name of the REPO where .tar.gz (service, meta, etcd2) files are stored.
+        --max_deploys MAX            *MAX deploy jobs sent to coreos-deploy at once. Others wait in a queue (default: 4).
+        --http_timeout SECS          Timeout of each outbound API request attempt in SECS seconds (default: 30).
+        --download_timeout SECS      *Timeout of each payload download attempt in SECS seconds (default: 600).
+        --max_payload_size MB        *Maximum size of a payload download in MB megabytes (default: 1024).
+        --payload_cache_dir DIR      DIR of the verified payloads cached by checksum
+                                     (default: /tmp/coreos-artifactory-monitor-cache/).
+        --payload_cache_size MB      *Maximum size of the payload cache in MB megabytes (default: 2048).
+        --http_retries MAX           MAX retries of a failed GET request to artifactory (default: 3).
+        --http_backoff MSECS         Backoff before the first retry in MSECS milliseconds, doubled for each
+                                     retry with jitter (default: 500).
+        --http_backoff_max MSECS     *Maximum backoff between retries in MSECS milliseconds (default: 10000).
+        --http_retry_budget SECS     *Maximum time spent on all attempts of a request in SECS seconds (default: 60).
+        --ready_polls MAX            MAX polling intervals since the last successful poll of a target before the
+                                     server is not ready (default: 3).
+        --tls_ca_file FILE           PEM FILE of CA certificates trusted by connections to artifactory and
+                                     coreos-deploy, added to the system roots.
+        --tls_cert_file FILE         PEM FILE of the client certificate sent to artifactory and coreos-deploy.
+        --tls_key_file FILE          PEM FILE of the key of --tls_cert_file.
+        --tls_min_version VERSION    Minimum TLS VERSION of outbound connections: 1.0, 1.1, 1.2 or 1.3
+                                     (default: the Go default).
+        --webhook_secret SECRET      SECRET used to verify artifactory webhook signatures (default: webhooks disabled).
+        --art_discovery MODE         How deploy request files are found: aql (one search of the deploy repo) or
+                                     folders (list each application folder) (default: aql).
+        --version_order ORDER        How deploy request versions are ORDERed to find the latest:
+                                     semantic, created or lexical (default: semantic).
+        --app_version_order LIST     LIST of app=ORDER pairs overriding --version_order by application.
+        --schedule_file FILE         JSON FILE of the deploy windows and freezes (default: deploy at any time).
+        --properties_file FILE       JSON FILE of the payload properties required to deploy (default: none).
+        --signatures_file FILE       JSON FILE of the public keys trusted to sign payloads and the environments
+                                     where payloads must be signed (default: none).
+        --app_include PATTERN        Only manage applications matching PATTERN, a glob (ex: video-*) or a regular
+                                     expression prefixed with re: (ex: re:video-(web|mobile)). Can be repeated.
+        --app_exclude PATTERN        Do not manage applications matching PATTERN. Can be repeated.
+        --filter_file FILE           JSON FILE of more patterns: {"include": [...], "exclude": [...]}.
+        --targets_file FILE          JSON FILE of the domain and environment targets to manage, each with its own
+                                     repos and coreos-deploy service (default: the single target above).
 	-p, --port PORT                  PORT to listen on (default: 8080).
     -L, --profiler_port PORT         *PORT the profiler is listening on (default: off).
     -X, --procs MAX                  *MAX processor cores to use from the machine.
@@ -36,6 +80,9 @@ Common options:
     -h, --help                       Show this message
     -V, --version                    Show version
 
+Commands:
+    validate-payload FILE.tar.gz...  Check the layout and metadata of payload files and exit 1 if any is invalid.
+
 Example:
 
     coreos-deploy -N "San Francisco" -H 0.0.0.0 -O example.com -E development \
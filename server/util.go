@@ -1,12 +1,9 @@
 package server
 
 import (
-	"bytes"
 	"crypto/rand"
-	"errors"
 	"fmt"
 	mr "math/rand"
-	"os/exec"
 	"time"
 )
 
@@ -30,19 +27,3 @@ func randomString(n int) string {
 	}
 	return string(result)
 }
-
-// execCmd executes an os command and formats any output from stdout/err
-func execCmd(cmd *exec.Cmd) (string, error) {
-	var (
-		stdout bytes.Buffer
-		stderr bytes.Buffer
-	)
-
-	cmd.Stdout, cmd.Stderr = &stdout, &stderr
-	err := cmd.Run()
-	result := stdout.String()
-	if err := stderr.String(); err != "" {
-		return "", errors.New(err)
-	}
-	return result, err
-}
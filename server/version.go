@@ -0,0 +1,271 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DeployVersion is a version of an application requested for deploy in artifactory.
+type DeployVersion struct {
+	Tag        string    `json:"tag"`        // The version tag ex: 1.0.1-22
+	Created    time.Time `json:"created"`    // When the deploy request file was created.
+	ModifiedBy string    `json:"modifiedBy"` // Who last modified the deploy request file.
+	Rollback   bool      `json:"rollback"`   // True if this is a rollback marker withdrawing the version.
+}
+
+// newDeployVersion returns the version of a deploy request file or rollback marker from its file name.
+func newDeployVersion(fileName string) *DeployVersion {
+	if strings.HasSuffix(fileName, rollbackFileExt) {
+		return &DeployVersion{Tag: strings.TrimSuffix(fileName, rollbackFileExt), Rollback: true}
+	}
+	return &DeployVersion{Tag: strings.TrimSuffix(fileName, deployFileExt)}
+}
+
+// versionLess reports whether version a is ordered before (is older than) version b.
+type versionLess func(a *DeployVersion, b *DeployVersion) bool
+
+// versionOrderings maps the version ordering names to their comparison functions.
+var versionOrderings = map[string]versionLess{
+	VersionOrderSemantic: semanticLess,
+	VersionOrderCreated:  createdLess,
+	VersionOrderLexical:  lexicalLess,
+}
+
+// versionOrdering returns the comparison function of a version ordering for a set of versions. The semantic
+// ordering compares the created timestamps of the whole set when any tag cannot be parsed, so a tag such as
+// nightly is ordered consistently against every other version.
+func versionOrdering(order string, versions []*DeployVersion) versionLess {
+	if order == VersionOrderSemantic {
+		for _, v := range versions {
+			if _, ok := parseVersionTag(v.Tag); !ok {
+				return createdLess
+			}
+		}
+	}
+	return versionOrderings[order]
+}
+
+// semanticLess orders by major.minor.patch-build tags. Tags that cannot be parsed are ordered before every
+// parsed tag, and by the created timestamp among themselves, so the order stays total.
+func semanticLess(a *DeployVersion, b *DeployVersion) bool {
+	ta, okA := parseVersionTag(a.Tag)
+	tb, okB := parseVersionTag(b.Tag)
+	switch {
+	case !okA && !okB:
+		return createdLess(a, b)
+	case !okA || !okB:
+		return !okA
+	}
+	return compareVersionTags(ta, tb) < 0
+}
+
+// createdLess orders by the artifactory created timestamp. Ties are broken by the tag.
+func createdLess(a *DeployVersion, b *DeployVersion) bool {
+	if !a.Created.Equal(b.Created) {
+		return a.Created.Before(b.Created)
+	}
+	return a.Tag < b.Tag
+}
+
+// lexicalLess orders by a plain string comparison of the tags.
+func lexicalLess(a *DeployVersion, b *DeployVersion) bool {
+	return a.Tag < b.Tag
+}
+
+// latestDeployVersion returns the highest ordered version from a list, or nil if the list is empty.
+func latestDeployVersion(versions []*DeployVersion, less versionLess) *DeployVersion {
+	var latest *DeployVersion
+	for _, v := range versions {
+		if latest == nil || less(latest, v) {
+			latest = v
+		}
+	}
+	return latest
+}
+
+// newestFirst sorts versions from the highest to the lowest ordered.
+type newestFirst struct {
+	versions []*DeployVersion
+	less     versionLess
+}
+
+func (a newestFirst) Len() int           { return len(a.versions) }
+func (a newestFirst) Swap(i, j int)      { a.versions[i], a.versions[j] = a.versions[j], a.versions[i] }
+func (a newestFirst) Less(i, j int) bool { return a.less(a.versions[j], a.versions[i]) }
+
+// requestedVersions splits the versions found in the deploy repo. It returns the deploy request files, the
+// versions still requested (those without a rollback marker), and whether each tag is still requested.
+func requestedVersions(versions []*DeployVersion) ([]*DeployVersion, []*DeployVersion, map[string]bool) {
+	withdrawn := make(map[string]bool)
+	for _, v := range versions {
+		if v.Rollback {
+			withdrawn[v.Tag] = true
+		}
+	}
+	files := make([]*DeployVersion, 0, len(versions))
+	requested := make([]*DeployVersion, 0, len(versions))
+	isRequested := make(map[string]bool)
+	for _, v := range versions {
+		if v.Rollback {
+			continue
+		}
+		files = append(files, v)
+		if !withdrawn[v.Tag] {
+			requested = append(requested, v)
+			isRequested[v.Tag] = true
+		}
+	}
+	return files, requested, isRequested
+}
+
+// isNewerVersion reports whether the latest version is ordered after the deployed version tag.
+// The deployed version is looked up in the list so its created timestamp can be used if needed.
+func isNewerVersion(latest *DeployVersion, deployed string, versions []*DeployVersion,
+	less versionLess) bool {
+	dv := &DeployVersion{Tag: deployed}
+	for _, v := range versions {
+		if v.Tag == deployed {
+			dv = v
+			break
+		}
+	}
+	return less(dv, latest)
+}
+
+// isOlderVersion reports whether the latest version is ordered before the deployed version tag. If the deployed
+// version is not in the list its created timestamp is unknown, and it is assumed to be the newer of the two.
+func isOlderVersion(latest *DeployVersion, deployed string, versions []*DeployVersion,
+	less versionLess) bool {
+	dv := &DeployVersion{Tag: deployed, Created: latest.Created.Add(time.Second)}
+	for _, v := range versions {
+		if v.Tag == deployed {
+			dv = v
+			break
+		}
+	}
+	return less(latest, dv)
+}
+
+// versionTag is a parsed version tag of the form major.minor.patch[-prerelease][-build].
+type versionTag struct {
+	major      int      // Incompatible API changes.
+	minor      int      // Backwards compatible functionality.
+	patch      int      // Backwards compatible fixes.
+	preRelease []string // Dot separated pre-release identifiers ex: rc.1 => ["rc", "1"]
+	build      int      // The build number or -1 if none was given.
+}
+
+// parseVersionTag parses a version tag such as 1.0.10-3, v2.1.0-rc.1-17 or 1.2.0-beta.
+// Any semver "+metadata" suffix is ignored. The boolean is false if the tag cannot be parsed.
+func parseVersionTag(tag string) (*versionTag, bool) {
+	tag = strings.TrimPrefix(strings.TrimPrefix(tag, "v"), "V")
+	if i := strings.Index(tag, "+"); i >= 0 {
+		tag = tag[:i]
+	}
+	parts := strings.Split(tag, "-")
+	core := strings.Split(parts[0], ".")
+	if len(core) != 3 {
+		return nil, false
+	}
+	nums := make([]int, 3)
+	for i, c := range core {
+		n, ok := parseNumeric(c)
+		if !ok {
+			return nil, false
+		}
+		nums[i] = n
+	}
+	v := &versionTag{major: nums[0], minor: nums[1], patch: nums[2], build: -1}
+
+	// The trailing numeric part is the build, anything between the core and build is pre-release.
+	rest := parts[1:]
+	if len(rest) > 0 {
+		if n, ok := parseNumeric(rest[len(rest)-1]); ok {
+			v.build = n
+			rest = rest[:len(rest)-1]
+		}
+	}
+	if len(rest) > 0 {
+		pre := strings.Join(rest, "-")
+		v.preRelease = strings.Split(pre, ".")
+		for _, id := range v.preRelease {
+			if id == "" {
+				return nil, false
+			}
+		}
+	}
+	return v, true
+}
+
+// parseNumeric returns the value of a string made up only of decimal digits.
+func parseNumeric(s string) (int, bool) {
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, false
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// compareVersionTags returns -1, 0 or 1 if a is less than, equal to or greater than b.
+// A release orders after any of its pre-releases, and the build number is compared last.
+func compareVersionTags(a *versionTag, b *versionTag) int {
+	if c := compareInts(a.major, b.major); c != 0 {
+		return c
+	}
+	if c := compareInts(a.minor, b.minor); c != 0 {
+		return c
+	}
+	if c := compareInts(a.patch, b.patch); c != 0 {
+		return c
+	}
+	if c := comparePreRelease(a.preRelease, b.preRelease); c != 0 {
+		return c
+	}
+	return compareInts(a.build, b.build)
+}
+
+// comparePreRelease compares pre-release identifiers using the semver precedence rules.
+func comparePreRelease(a []string, b []string) int {
+	switch {
+	case len(a) == 0 && len(b) == 0:
+		return 0
+	case len(a) == 0:
+		return 1
+	case len(b) == 0:
+		return -1
+	}
+	for i := 0; i < len(a) && i < len(b); i++ {
+		na, numA := parseNumeric(a[i])
+		nb, numB := parseNumeric(b[i])
+		switch {
+		case numA && numB:
+			if c := compareInts(na, nb); c != 0 {
+				return c
+			}
+		case numA:
+			return -1
+		case numB:
+			return 1
+		default:
+			if c := strings.Compare(a[i], b[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return compareInts(len(a), len(b))
+}
+
+// compareInts returns -1, 0 or 1 if a is less than, equal to or greater than b.
+func compareInts(a int, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
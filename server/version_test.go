@@ -0,0 +1,208 @@
+package server
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestParseVersionTag(t *testing.T) {
+	tests := []struct {
+		tag   string
+		valid bool
+		major int
+		minor int
+		patch int
+		pre   int
+		build int
+	}{
+		{"1.0.1-22", true, 1, 0, 1, 0, 22},
+		{"1.0.10", true, 1, 0, 10, 0, -1},
+		{"v2.3.4-5", true, 2, 3, 4, 0, 5},
+		{"1.0.1-rc.1-7", true, 1, 0, 1, 2, 7},
+		{"1.0.1-beta", true, 1, 0, 1, 1, -1},
+		{"1.0.1-22+sha.ab12", true, 1, 0, 1, 0, 22},
+		{"1.0-22", false, 0, 0, 0, 0, 0},
+		{"1.0.x-22", false, 0, 0, 0, 0, 0},
+		{"1.0.1-rc..1", false, 0, 0, 0, 0, 0},
+		{"latest", false, 0, 0, 0, 0, 0},
+		{"", false, 0, 0, 0, 0, 0},
+	}
+	for _, tc := range tests {
+		v, ok := parseVersionTag(tc.tag)
+		if ok != tc.valid {
+			t.Errorf("Tag %q: expected valid %t, received %t.", tc.tag, tc.valid, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if v.major != tc.major || v.minor != tc.minor || v.patch != tc.patch ||
+			len(v.preRelease) != tc.pre || v.build != tc.build {
+			t.Errorf("Tag %q: parsed incorrectly: %+v", tc.tag, v)
+		}
+	}
+}
+
+func TestSemanticLess(t *testing.T) {
+	older := time.Date(2015, 9, 1, 0, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+	tests := []struct {
+		a        *DeployVersion
+		b        *DeployVersion
+		expected bool
+	}{
+		{&DeployVersion{Tag: "1.0.9-40"}, &DeployVersion{Tag: "1.0.10-3"}, true},
+		{&DeployVersion{Tag: "1.0.10-3"}, &DeployVersion{Tag: "1.0.9-40"}, false},
+		{&DeployVersion{Tag: "1.0.1-9"}, &DeployVersion{Tag: "1.0.1-10"}, true},
+		{&DeployVersion{Tag: "1.9.0-1"}, &DeployVersion{Tag: "1.10.0-1"}, true},
+		{&DeployVersion{Tag: "2.0.0-1"}, &DeployVersion{Tag: "10.0.0-1"}, true},
+		{&DeployVersion{Tag: "1.0.1"}, &DeployVersion{Tag: "1.0.1-1"}, true},
+		{&DeployVersion{Tag: "1.0.1-rc.1-30"}, &DeployVersion{Tag: "1.0.1-2"}, true},
+		{&DeployVersion{Tag: "1.0.1-alpha-1"}, &DeployVersion{Tag: "1.0.1-beta-1"}, true},
+		{&DeployVersion{Tag: "1.0.1-rc.2-1"}, &DeployVersion{Tag: "1.0.1-rc.10-1"}, true},
+		{&DeployVersion{Tag: "1.0.1-rc.1"}, &DeployVersion{Tag: "1.0.1-rc.1.1"}, true},
+		{&DeployVersion{Tag: "1.0.1-1"}, &DeployVersion{Tag: "1.0.1-rc"}, false},
+		{&DeployVersion{Tag: "v1.0.1-5"}, &DeployVersion{Tag: "1.0.1-6"}, true},
+		{&DeployVersion{Tag: "1.0.1-5"}, &DeployVersion{Tag: "1.0.1-5"}, false},
+		// Unparseable tags order first, by the created timestamp.
+		{&DeployVersion{Tag: "zeta", Created: older}, &DeployVersion{Tag: "alpha", Created: newer}, true},
+		{&DeployVersion{Tag: "9.9.9-9", Created: newer}, &DeployVersion{Tag: "hotfix", Created: older}, false},
+		{&DeployVersion{Tag: "hotfix", Created: older}, &DeployVersion{Tag: "1.0.0-1", Created: newer}, true},
+		{&DeployVersion{Tag: "hotfix", Created: newer}, &DeployVersion{Tag: "1.0.0-1", Created: older}, true},
+		// Unknown created timestamps order first.
+		{&DeployVersion{Tag: "hotfix"}, &DeployVersion{Tag: "nightly", Created: older}, true},
+	}
+	for _, tc := range tests {
+		if actual := semanticLess(tc.a, tc.b); actual != tc.expected {
+			t.Errorf("semanticLess(%s, %s): expected %t, received %t.", tc.a.Tag, tc.b.Tag, tc.expected, actual)
+		}
+	}
+}
+
+func TestVersionOrderingTotal(t *testing.T) {
+	t1 := time.Date(2015, 9, 1, 0, 0, 0, 0, time.UTC)
+	versions := []*DeployVersion{
+		{Tag: "1.0.10-1", Created: t1},
+		{Tag: "1.0.9-1", Created: t1.Add(2 * time.Hour)},
+		{Tag: "hotfix", Created: t1.Add(time.Hour)},
+	}
+	tests := []struct {
+		name     string
+		less     versionLess
+		expected string
+	}{
+		{"semanticLess", semanticLess, "1.0.10-1"},
+		{"semantic ordering of the set", versionOrdering(VersionOrderSemantic, versions), "1.0.9-1"},
+		{"semantic ordering without unparseable tags", versionOrdering(VersionOrderSemantic, versions[:2]),
+			"1.0.10-1"},
+	}
+	perms := [][]int{{0, 1, 2}, {0, 2, 1}, {1, 0, 2}, {1, 2, 0}, {2, 0, 1}, {2, 1, 0}}
+	for _, tc := range tests {
+		// No cycles: if a < b and b < c then a < c.
+		for _, p := range perms {
+			a, b, c := versions[p[0]], versions[p[1]], versions[p[2]]
+			if tc.less(a, b) && tc.less(b, c) && !tc.less(a, c) {
+				t.Errorf("%s: %s < %s < %s but not %s < %s", tc.name, a.Tag, b.Tag, c.Tag, a.Tag, c.Tag)
+			}
+		}
+		// The latest and the sort order do not depend on the order of the list.
+		for _, p := range perms {
+			list := []*DeployVersion{versions[p[0]], versions[p[1]], versions[p[2]]}
+			if latest := latestDeployVersion(list, tc.less); latest.Tag != tc.expected {
+				t.Errorf("%s: expected latest %s, received %s", tc.name, tc.expected, latest.Tag)
+			}
+			sort.Sort(newestFirst{list, tc.less})
+			if list[0].Tag != tc.expected {
+				t.Errorf("%s: expected %s sorted first, received %s", tc.name, tc.expected, list[0].Tag)
+			}
+		}
+	}
+	if versionOrdering(VersionOrderLexical, versions)(versions[1], versions[0]) {
+		t.Errorf("Expected the lexical ordering whatever the tags.")
+	}
+}
+
+func TestCreatedAndLexicalLess(t *testing.T) {
+	older := time.Date(2015, 9, 1, 0, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Minute)
+	a := &DeployVersion{Tag: "1.0.10-3", Created: older}
+	b := &DeployVersion{Tag: "1.0.9-40", Created: newer}
+	if !createdLess(a, b) || createdLess(b, a) {
+		t.Errorf("createdLess should order by created timestamp.")
+	}
+	if createdLess(a, &DeployVersion{Tag: "1.0.10-2", Created: older}) {
+		t.Errorf("createdLess should break ties by tag.")
+	}
+	if !lexicalLess(a, b) || lexicalLess(b, a) {
+		t.Errorf("lexicalLess should order by string comparison.")
+	}
+}
+
+func TestLatestDeployVersion(t *testing.T) {
+	versions := []*DeployVersion{
+		{Tag: "1.0.9-40"},
+		{Tag: "1.0.10-3"},
+		{Tag: "1.0.10-2"},
+		{Tag: "1.0.1-99"},
+	}
+	tests := []struct {
+		order    string
+		expected string
+	}{
+		{VersionOrderSemantic, "1.0.10-3"},
+		{VersionOrderLexical, "1.0.9-40"},
+	}
+	for _, tc := range tests {
+		latest := latestDeployVersion(versions, versionOrderings[tc.order])
+		if latest == nil || latest.Tag != tc.expected {
+			t.Errorf("Order %s: expected latest %s, received %v.", tc.order, tc.expected, latest)
+		}
+	}
+	if latestDeployVersion(nil, semanticLess) != nil {
+		t.Errorf("Latest of an empty list should be nil.")
+	}
+}
+
+func TestIsNewerVersion(t *testing.T) {
+	older := time.Date(2015, 9, 1, 0, 0, 0, 0, time.UTC)
+	versions := []*DeployVersion{
+		{Tag: "hotfix", Created: older},
+		{Tag: "nightly", Created: older.Add(time.Hour)},
+	}
+	tests := []struct {
+		latest   *DeployVersion
+		deployed string
+		expected bool
+	}{
+		{&DeployVersion{Tag: "1.0.10-3"}, "1.0.9-40", true},
+		{&DeployVersion{Tag: "1.0.9-40"}, "1.0.10-3", false},
+		{&DeployVersion{Tag: "1.0.10-3"}, "1.0.10-3", false},
+		{versions[1], "hotfix", true},
+		{versions[0], "nightly", false},
+		{versions[1], "removed-tag", true},
+	}
+	for _, tc := range tests {
+		if actual := isNewerVersion(tc.latest, tc.deployed, versions, semanticLess); actual != tc.expected {
+			t.Errorf("isNewerVersion(%s, %s): expected %t, received %t.", tc.latest.Tag, tc.deployed,
+				tc.expected, actual)
+		}
+	}
+}
+
+func TestStringMapValue(t *testing.T) {
+	var m map[string]string
+	v := (*StringMapValue)(&m)
+	if err := v.Set("video-mobile=created, api=lexical"); err != nil {
+		t.Errorf("Set should accept valid pairs: %s", err.Error())
+	}
+	if m["video-mobile"] != VersionOrderCreated || m["api"] != VersionOrderLexical {
+		t.Errorf("Set parsed incorrectly: %v", m)
+	}
+	if v.String() != "api=lexical,video-mobile=created" {
+		t.Errorf("String formatted incorrectly: %s", v.String())
+	}
+	if err := v.Set("missing-separator"); err == nil {
+		t.Errorf("Set should reject a pair without a value.")
+	}
+}
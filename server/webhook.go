@@ -0,0 +1,58 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"path"
+	"strings"
+)
+
+// ArtWebhookEvent is the payload of an artifactory webhook event.
+type ArtWebhookEvent struct {
+	Domain    string               `json:"domain"`     // The event domain ex: "artifact"
+	EventType string               `json:"event_type"` // The event type ex: "deployed"
+	Data      *ArtWebhookEventData `json:"data"`       // The artifact the event is about.
+}
+
+// ArtWebhookEventData describes the artifact of a webhook event. See ArtWebhookEvent.
+type ArtWebhookEventData struct {
+	RepoKey string `json:"repo_key"` // The repository of the artifact.
+	Path    string `json:"path"`     // The path of the artifact in the repo ex: "video-mobile/1.0.1-22.deploy"
+	Name    string `json:"name"`     // The file name of the artifact ex: "1.0.1-22.deploy"
+	Sha256  string `json:"sha256"`   // The checksum of the artifact.
+	Size    int64  `json:"size"`     // The size of the artifact in bytes.
+}
+
+// deployRequestApp returns the application name if the event is a deploy request file or rollback marker
+// being deployed into, or deleted from, the given repo.
+func (e *ArtWebhookEvent) deployRequestApp(repo string) (string, bool) {
+	if e.Domain != webhookDomainArtifact || e.Data == nil ||
+		(e.EventType != webhookEventDeployed && e.EventType != webhookEventDeleted) {
+		return "", false
+	}
+	if ext := path.Ext(e.Data.Path); e.Data.RepoKey != repo || (ext != deployFileExt && ext != rollbackFileExt) {
+		return "", false
+	}
+	// Only files directly inside an application folder are deploy requests.
+	parts := strings.Split(strings.Trim(e.Data.Path, "/"), "/")
+	if len(parts) != 2 || parts[0] == "" {
+		return "", false
+	}
+	return parts[0], true
+}
+
+// validWebhookSignature returns true if the signature is the hex HMAC-SHA256 of the body using the secret.
+// An optional "sha256=" prefix on the signature is allowed.
+func validWebhookSignature(secret string, body []byte, signature string) bool {
+	if secret == "" || signature == "" {
+		return false
+	}
+	sig, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(signature), "sha256="))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(sig, mac.Sum(nil))
+}
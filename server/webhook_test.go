@@ -0,0 +1,124 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/composer22/coreos-artifactory-monitor/logger"
+)
+
+const testWebhookSecret = "W3bH00kS3cr3t"
+
+// signWebhook returns the hex HMAC-SHA256 signature of a payload.
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestValidWebhookSignature(t *testing.T) {
+	body := []byte(`{"domain":"artifact"}`)
+	sig := signWebhook(testWebhookSecret, body)
+	tests := []struct {
+		secret    string
+		signature string
+		expected  bool
+	}{
+		{testWebhookSecret, sig, true},
+		{testWebhookSecret, "sha256=" + sig, true},
+		{testWebhookSecret, signWebhook("other", body), false},
+		{testWebhookSecret, "not-hex", false},
+		{testWebhookSecret, "", false},
+		{"", signWebhook("", body), false},
+	}
+	for i, tc := range tests {
+		if actual := validWebhookSignature(tc.secret, body, tc.signature); actual != tc.expected {
+			t.Errorf("Test %d: expected %t, received %t.", i, tc.expected, actual)
+		}
+	}
+}
+
+func TestArtWebhookHandler(t *testing.T) {
+	s := New(&Options{ArtDeployRepo: testDeployRepo, WebhookSecret: testWebhookSecret},
+		logger.New(logger.Error, false))
+	s.filter, _ = newAppFilter(nil, []string{"search-*"})
+
+	tests := []struct {
+		method   string
+		body     string
+		secret   string
+		status   int
+		expected string // The application sent to the monitor, if any.
+	}{
+		{httpPost, `{"domain":"artifact","event_type":"deployed","data":{"repo_key":"cluster-deploys",` +
+			`"path":"video-mobile/1.0.1-23.deploy","name":"1.0.1-23.deploy"}}`, testWebhookSecret,
+			http.StatusAccepted, "video-mobile"},
+		{httpPost, `{"domain":"artifact","event_type":"deployed","data":{"repo_key":"cluster-payloads",` +
+			`"path":"video-mobile/1.0.1-23.deploy","name":"1.0.1-23.deploy"}}`, testWebhookSecret,
+			http.StatusOK, ""},
+		{httpPost, `{"domain":"artifact","event_type":"deleted","data":{"repo_key":"cluster-deploys",` +
+			`"path":"video-mobile/1.0.1-23.deploy","name":"1.0.1-23.deploy"}}`, testWebhookSecret,
+			http.StatusAccepted, "video-mobile"},
+		{httpPost, `{"domain":"artifact","event_type":"deployed","data":{"repo_key":"cluster-deploys",` +
+			`"path":"video-mobile/1.0.1-23.rollback","name":"1.0.1-23.rollback"}}`, testWebhookSecret,
+			http.StatusAccepted, "video-mobile"},
+		{httpPost, `{"domain":"artifact","event_type":"moved","data":{"repo_key":"cluster-deploys",` +
+			`"path":"video-mobile/1.0.1-23.deploy","name":"1.0.1-23.deploy"}}`, testWebhookSecret,
+			http.StatusOK, ""},
+		{httpPost, `{"domain":"artifact","event_type":"deployed","data":{"repo_key":"cluster-deploys",` +
+			`"path":"video-mobile/README.md","name":"README.md"}}`, testWebhookSecret, http.StatusOK, ""},
+		{httpPost, `{"domain":"artifact","event_type":"deployed","data":{"repo_key":"cluster-deploys",` +
+			`"path":"search-api/1.2.0-1.deploy","name":"1.2.0-1.deploy"}}`, testWebhookSecret, http.StatusOK, ""},
+		{httpPost, `{"domain":"artifact","event_type":"deployed","data":{"repo_key":"cluster-deploys",` +
+			`"path":"video-mobile/1.0.1-23.deploy","name":"1.0.1-23.deploy"}}`, "wrong",
+			http.StatusUnauthorized, ""},
+		{httpPost, `{not json`, testWebhookSecret, http.StatusBadRequest, ""},
+		{httpPost, `{"domain":"artifact","event_type":"deployed","data":{"repo_key":"cluster-deploys",` +
+			`"path":"video-mobile/1.0.1-23.deploy","name":"1.0.1-23.deploy","padding":"` +
+			strings.Repeat("x", 2*maxWebhookBody) + `"}}`, testWebhookSecret, http.StatusRequestEntityTooLarge, ""},
+		{httpGet, ``, testWebhookSecret, http.StatusMethodNotAllowed, ""},
+	}
+	for i, tc := range tests {
+		body := []byte(tc.body)
+		cr := &countingReader{r: bytes.NewReader(body)}
+		req, _ := http.NewRequest(tc.method, httpRouteV1ArtWebhook, cr)
+		req.Header.Set(webhookSignatureHeader, signWebhook(tc.secret, body))
+		w := httptest.NewRecorder()
+		s.srvr.Handler.ServeHTTP(w, req) // Through the middleware, which must not read the body.
+		if w.Code != tc.status {
+			t.Errorf("Test %d: expected status %d, received %d.", i, tc.status, w.Code)
+		}
+		if cr.n >= 2*maxWebhookBody {
+			t.Errorf("Test %d: expected the body read to stop at the limit, received %d bytes.", i, cr.n)
+		}
+		select {
+		case appName := <-s.monitors[0].check:
+			if appName != tc.expected {
+				t.Errorf("Test %d: expected check of %q, received %q.", i, tc.expected, appName)
+			}
+		default:
+			if tc.expected != "" {
+				t.Errorf("Test %d: expected check of %q, received none.", i, tc.expected)
+			}
+		}
+	}
+}
+
+// countingReader counts the bytes read from a reader.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
@@ -0,0 +1,247 @@
+// Package validate checks the layout and metadata of a deploy payload tar.gz, so a broken payload fails in
+// CI or before it is extracted rather than mid-deploy.
+package validate
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	coscl "github.com/composer22/coreos-deploy-client/client"
+)
+
+const (
+	PayloadExt  = ".tar.gz"   // The extension of a payload file.
+	metaExt     = ".json"     // The metadata file.
+	serviceExt  = ".service"  // A service unit file.
+	templateExt = ".tmpl"     // A service unit template file.
+	etcd2Ext    = ".etcd2"    // The etcd2 keys file.
+	maxMetaSize = 1024 * 1024 // Maximum size of a metadata file read.
+)
+
+// Payload is the content of a valid payload.
+type Payload struct {
+	Dir   string  // The directory of the payload, named as the payload file.
+	Units []*Unit // The service units of the payload, deployed together, ordered by directory.
+}
+
+// Unit is a service unit of a payload with its own metadata.
+type Unit struct {
+	Dir         string                     // The unit directory, or empty for the files at the top of the payload.
+	MetaFile    string                     // The path of the metadata file in the payload directory.
+	ServiceFile string                     // The path of the .service or .tmpl unit file in the payload directory.
+	Etcd2File   string                     // The path of the .etcd2 file in the payload directory, if any.
+	Meta        *coscl.ServiceTemplateVars // The metadata.
+}
+
+// unitFiles are the files of a unit found in a payload.
+type unitFiles struct {
+	metas    []string          // The metadata files.
+	services []string          // The .service and .tmpl files.
+	etcd2s   []string          // The .etcd2 files.
+	metaData map[string][]byte // The content of the metadata files.
+}
+
+// Error lists every problem found in a payload.
+type Error struct {
+	Problems []string // A description of each problem.
+}
+
+// Error is an implementation of the error interface.
+func (e *Error) Error() string {
+	return strings.Join(e.Problems, "; ")
+}
+
+// add records a problem.
+func (e *Error) add(format string, args ...interface{}) {
+	e.Problems = append(e.Problems, fmt.Sprintf(format, args...))
+}
+
+// File validates a payload file. The directory expected in it is the name of the file without .tar.gz.
+func File(filePath string) (*Payload, error) {
+	name := filepath.Base(filePath)
+	if !strings.HasSuffix(name, PayloadExt) {
+		return nil, &Error{[]string{fmt.Sprintf("Payload %s is not a %s file", name, PayloadExt)}}
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Reader(f, strings.TrimSuffix(name, PayloadExt))
+}
+
+// Reader validates a payload read from a tar.gz stream. Every entry must be in the directory dir
+// ex: example.com-development-video-mobile-1.0.1-22. If the directory holds unit files they are the only unit,
+// otherwise each subdirectory holding unit files is a unit. A unit has exactly one .json metadata file, one
+// .service or .tmpl unit file and at most one .etcd2 file. The version of each metadata must end the directory
+// name, and the names of the units must be unique.
+func Reader(r io.Reader, dir string) (*Payload, error) {
+	verr := &Error{}
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		verr.add("Cannot read the payload: %s", err.Error())
+		return nil, verr
+	}
+	defer gz.Close()
+
+	units := make(map[string]*unitFiles)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			verr.add("Cannot read the payload: %s", err.Error())
+			return nil, verr
+		}
+		if hdr.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		file, ok := entryFile(hdr.Name, dir)
+		if !ok {
+			verr.add("Entry %s is not in the directory %s", hdr.Name, dir)
+			continue
+		}
+		// Only the regular files at the top of the directory or of its subdirectories are used.
+		if file == "" || strings.Count(file, "/") > 1 ||
+			(hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA) {
+			continue
+		}
+		unitDir := ""
+		if i := strings.Index(file, "/"); i >= 0 {
+			unitDir = file[:i]
+		}
+		uf, ok := units[unitDir]
+		if !ok {
+			uf = &unitFiles{metaData: make(map[string][]byte)}
+		}
+		switch path.Ext(file) {
+		case metaExt:
+			uf.metas = append(uf.metas, file)
+			b, err := ioutil.ReadAll(io.LimitReader(tr, maxMetaSize))
+			if err != nil {
+				verr.add("Cannot read metadata file %s: %s", file, err.Error())
+			}
+			uf.metaData[file] = b
+		case serviceExt, templateExt:
+			uf.services = append(uf.services, file)
+		case etcd2Ext:
+			uf.etcd2s = append(uf.etcd2s, file)
+		default:
+			continue
+		}
+		units[unitDir] = uf
+	}
+
+	// The files at the top of the directory are a single unit, and any subdirectories are ignored.
+	var unitDirs []string
+	if _, ok := units[""]; ok || len(units) == 0 {
+		unitDirs = []string{""}
+	} else {
+		for unitDir := range units {
+			unitDirs = append(unitDirs, unitDir)
+		}
+		sort.Strings(unitDirs)
+	}
+
+	p := &Payload{Dir: dir}
+	names := make(map[string]string)
+	for _, unitDir := range unitDirs {
+		uf := units[unitDir]
+		if uf == nil {
+			uf = &unitFiles{}
+		}
+		where := ""
+		if unitDir != "" {
+			where = " in " + unitDir
+		}
+		u := &Unit{Dir: unitDir}
+		u.MetaFile = single(verr, uf.metas, "metadata .json", where, true)
+		u.ServiceFile = single(verr, uf.services, "service unit .service or .tmpl", where, true)
+		u.Etcd2File = single(verr, uf.etcd2s, "etcd2 keys .etcd2", where, false)
+		if u.MetaFile != "" {
+			u.Meta = metadata(verr, u.MetaFile, uf.metaData[u.MetaFile], dir)
+		}
+		if u.Meta != nil && u.Meta.Name != "" {
+			if other, ok := names[u.Meta.Name]; ok {
+				verr.add("Metadata name %s of %s is also the name of %s", u.Meta.Name, u.MetaFile, other)
+			}
+			names[u.Meta.Name] = u.MetaFile
+		}
+		p.Units = append(p.Units, u)
+	}
+	if len(verr.Problems) > 0 {
+		return nil, verr
+	}
+	return p, nil
+}
+
+// entryFile returns the path of an entry within the directory, or false if it is not in the directory.
+// The path of the directory itself, or of the root of the archive, is empty.
+func entryFile(name string, dir string) (string, bool) {
+	name = strings.TrimSuffix(strings.TrimPrefix(name, "./"), "/")
+	if name == "" || name == dir {
+		return "", true
+	}
+	if !strings.HasPrefix(name, dir+"/") {
+		return "", false
+	}
+	file := strings.TrimPrefix(name, dir+"/")
+	for _, part := range strings.Split(file, "/") {
+		if part == ".." {
+			return "", false
+		}
+	}
+	return file, true
+}
+
+// single returns the only file of a kind in a unit, recording a problem if there is more than one, or none
+// when the file is required.
+func single(verr *Error, files []string, kind string, where string, required bool) string {
+	switch {
+	case len(files) == 0 && required:
+		verr.add("No %s file found%s", kind, where)
+	case len(files) > 1:
+		verr.add("More than one %s file found%s: %s", kind, where, strings.Join(files, ", "))
+	case len(files) == 1:
+		return files[0]
+	}
+	return ""
+}
+
+// metadata parses and checks a metadata file. Unknown fields are rejected to catch typos.
+func metadata(verr *Error, file string, b []byte, dir string) *coscl.ServiceTemplateVars {
+	var meta coscl.ServiceTemplateVars
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&meta); err != nil {
+		verr.add("Cannot parse metadata file %s: %s", file, err.Error())
+		return nil
+	}
+	if meta.Name == "" {
+		verr.add("Metadata file %s has no name", file)
+	}
+	if meta.Version == "" {
+		verr.add("Metadata file %s has no version", file)
+	} else if !strings.HasSuffix(dir, "-"+meta.Version) {
+		verr.add("Metadata version %s of %s does not match the directory %s", meta.Version, file, dir)
+	}
+	if meta.ImageVersion == "" {
+		verr.add("Metadata file %s has no imageVersion", file)
+	}
+	if meta.NumInstances <= 0 {
+		verr.add("Metadata numInstances of %s must be greater than zero", file)
+	}
+	return &meta
+}
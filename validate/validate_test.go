@@ -0,0 +1,160 @@
+package validate
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"testing"
+)
+
+const (
+	testExample = "../example.com-development-video-mobile-1.0.1-22.tar.gz"
+	testDir     = "example.com-development-video-mobile-1.0.1-22"
+	testMeta    = `{"name":"example-video-mobile","version":"1.0.1-22","imageVersion":"1.0.1-22","numInstances":2}`
+	testSidecar = `{"name":"example-video-proxy","version":"1.0.1-22","imageVersion":"2.1.0","numInstances":2}`
+)
+
+// testPayload returns a tar.gz of files by entry name. Names ending in / are directories.
+func testPayload(t *testing.T, files ...string) *bytes.Buffer {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for i := 0; i < len(files); i += 2 {
+		hdr := &tar.Header{Name: files[i], Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(files[i+1]))}
+		if strings.HasSuffix(files[i], "/") {
+			hdr.Typeflag, hdr.Mode, hdr.Size = tar.TypeDir, 0755, 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		tw.Write([]byte(files[i+1]))
+	}
+	tw.Close()
+	gz.Close()
+	return &buf
+}
+
+func TestFileExample(t *testing.T) {
+	p, err := File(testExample)
+	if err != nil {
+		t.Fatalf("Expected the example payload to be valid: %s", err.Error())
+	}
+	if p.Dir != testDir || len(p.Units) != 1 {
+		t.Fatalf("Unexpected payload: %+v", p)
+	}
+	u := p.Units[0]
+	if u.Dir != "" || u.MetaFile != testDir+".metadata.json" ||
+		u.ServiceFile != "example-video-mobile@.service.tmpl" || u.Etcd2File != testDir+".etcd2" {
+		t.Errorf("Unexpected payload files: %+v", u)
+	}
+	if u.Meta == nil || u.Meta.Name != "example-video-mobile" || u.Meta.NumInstances != 2 {
+		t.Errorf("Unexpected payload metadata: %+v", u.Meta)
+	}
+	if _, err := File("../README.md"); err == nil {
+		t.Errorf("Expected an error validating a file that is not a .tar.gz.")
+	}
+	if _, err := File("../missing" + PayloadExt); err == nil {
+		t.Errorf("Expected an error validating a missing file.")
+	}
+}
+
+func TestReader(t *testing.T) {
+	d := testDir + "/"
+	tests := []struct {
+		name     string
+		files    []string
+		problems []string
+	}{
+		{"valid", []string{d, "", d + "meta.json", testMeta, d + "app@.service", "[Unit]",
+			d + "README.md", "notes"}, nil},
+		{"dot directory", []string{"./", "", "./" + d + "meta.json", testMeta,
+			"./" + d + "app@.service.tmpl", "x"}, nil},
+		{"subdirectory ignored", []string{d + "meta.json", testMeta, d + "app.service", "x",
+			d + "docs/other.json", "{}"}, nil},
+		{"wrong directory", []string{"app-1.0.1-22/meta.json", testMeta, d + "app.service", "x"},
+			[]string{"not in the directory", "No metadata"}},
+		{"parent path", []string{d + "../evil.service", "x", d + "meta.json", testMeta, d + "app.service", "x"},
+			[]string{"not in the directory"}},
+		{"no metadata", []string{d + "app.service", "x"}, []string{"No metadata"}},
+		{"two metadata", []string{d + "a.json", testMeta, d + "b.json", testMeta, d + "app.service", "x"},
+			[]string{"More than one metadata .json file found: a.json, b.json"}},
+		{"no service", []string{d + "meta.json", testMeta}, []string{"No service unit"}},
+		{"two services", []string{d + "meta.json", testMeta, d + "a.service", "x", d + "b.tmpl", "x"},
+			[]string{"More than one service unit"}},
+		{"two etcd2", []string{d + "meta.json", testMeta, d + "app.service", "x", d + "a.etcd2", "k v",
+			d + "b.etcd2", "k v"}, []string{"More than one etcd2"}},
+		{"typo", []string{d + "meta.json", strings.Replace(testMeta, "numInstances", "numInstance", 1),
+			d + "app.service", "x"}, []string{"unknown field"}},
+		{"bad json", []string{d + "meta.json", `{"name":`, d + "app.service", "x"}, []string{"Cannot parse"}},
+		{"missing fields", []string{d + "meta.json", `{}`, d + "app.service", "x"},
+			[]string{"no name", "no version", "no imageVersion", "numInstances"}},
+		{"units", []string{d + "web/meta.json", testMeta, d + "web/web.service", "x",
+			d + "sidecar/meta.json", testSidecar, d + "sidecar/sidecar.service", "x", d + "sidecar/s.etcd2", "k v",
+			d + "web/conf/other.json", "{}"}, nil},
+		{"unit without service", []string{d + "web/meta.json", testMeta, d + "web/web.service", "x",
+			d + "sidecar/meta.json", testSidecar},
+			[]string{"No service unit .service or .tmpl file found in sidecar"}},
+		{"duplicate unit names", []string{d + "web/meta.json", testMeta, d + "web/web.service", "x",
+			d + "copy/meta.json", testMeta, d + "copy/copy.service", "x"},
+			[]string{"Metadata name example-video-mobile of web/meta.json is also the name of copy/meta.json"}},
+		{"version mismatch", []string{d + "meta.json", strings.Replace(testMeta, `"version":"1.0.1-22"`,
+			`"version":"1.0.1-2"`, 1), d + "app.service", "x"}, []string{"does not match the directory"}},
+	}
+	for _, tc := range tests {
+		p, err := Reader(testPayload(t, tc.files...), testDir)
+		if len(tc.problems) == 0 {
+			if err != nil || p == nil {
+				t.Errorf("%s: unexpected error: %v", tc.name, err)
+			}
+			continue
+		}
+		verr, ok := err.(*Error)
+		if !ok {
+			t.Errorf("%s: expected a validation error, received %v", tc.name, err)
+			continue
+		}
+		if len(verr.Problems) != len(tc.problems) {
+			t.Errorf("%s: expected %d problems, received %q", tc.name, len(tc.problems), verr.Problems)
+			continue
+		}
+		for i, problem := range tc.problems {
+			if !strings.Contains(verr.Problems[i], problem) {
+				t.Errorf("%s: expected problem %q, received %q", tc.name, problem, verr.Problems[i])
+			}
+		}
+	}
+
+	if _, err := Reader(strings.NewReader("not gzip"), testDir); err == nil {
+		t.Errorf("Expected an error validating a payload that is not gzipped.")
+	}
+}
+
+func TestReaderUnits(t *testing.T) {
+	d := testDir + "/"
+	p, err := Reader(testPayload(t, d+"web/meta.json", testMeta, d+"web/web@.service.tmpl", "x",
+		d+"sidecar/meta.json", testSidecar, d+"sidecar/sidecar@.service", "x", d+"sidecar/s.etcd2", "k v",
+		d+"README.md", "notes"), testDir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if len(p.Units) != 2 {
+		t.Fatalf("Expected 2 units, received %d", len(p.Units))
+	}
+	expected := []Unit{
+		{Dir: "sidecar", MetaFile: "sidecar/meta.json", ServiceFile: "sidecar/sidecar@.service",
+			Etcd2File: "sidecar/s.etcd2"},
+		{Dir: "web", MetaFile: "web/meta.json", ServiceFile: "web/web@.service.tmpl"},
+	}
+	names := []string{"example-video-proxy", "example-video-mobile"}
+	for i, u := range p.Units {
+		e := expected[i]
+		if u.Dir != e.Dir || u.MetaFile != e.MetaFile || u.ServiceFile != e.ServiceFile ||
+			u.Etcd2File != e.Etcd2File {
+			t.Errorf("Unit %d: expected %+v, received %+v", i, e, u)
+		}
+		if u.Meta == nil || u.Meta.Name != names[i] {
+			t.Errorf("Unit %d: expected metadata name %s, received %+v", i, names[i], u.Meta)
+		}
+	}
+}